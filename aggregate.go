@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ihoru/instapaper-to-exist/config"
+	"github.com/ihoru/instapaper-to-exist/existio_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// Reading bundles the stored data attribute values are computed from
+type Reading struct {
+	Articles     state.Articles
+	ReadingStats state.ReadingStats
+	Progress     state.Progress
+}
+
+// Aggregator computes the value of an attribute for a date
+type Aggregator func(date string, reading *Reading) interface{}
+
+// aggregators maps aggregation names to their implementation
+var aggregators = map[string]Aggregator{
+	config.AggregateCount: func(date string, reading *Reading) interface{} {
+		return reading.ReadingStats[date]
+	},
+	config.AggregateProgress: func(date string, reading *Reading) interface{} {
+		return int(reading.Progress.Completed[date])
+	},
+	config.AggregateProgressFraction: func(date string, reading *Reading) interface{} {
+		return math.Round(reading.Progress.Gained[date]*100) / 100
+	},
+	config.AggregateWords: func(date string, reading *Reading) interface{} {
+		words, _ := DayReading(date, reading.Articles)
+		return words
+	},
+	config.AggregateMinutes: func(date string, reading *Reading) interface{} {
+		_, minutes := DayReading(date, reading.Articles)
+		return minutes
+	},
+}
+
+// folderAggregator counts the articles archived out of a folder
+func folderAggregator(folder string) Aggregator {
+	return func(date string, reading *Reading) interface{} {
+		count := 0
+		for _, article := range reading.Articles {
+			if article.Day == date && strings.EqualFold(article.Folder, folder) {
+				count++
+			}
+		}
+		return count
+	}
+}
+
+// Aggregate computes the value of the mapped attribute for a date
+func Aggregate(mapping config.AttributeMapping, date string, reading *Reading) interface{} {
+	if folder, ok := mapping.Folder(); ok {
+		return folderAggregator(folder)(date, reading)
+	}
+	return aggregators[mapping.Aggregate](date, reading)
+}
+
+// AttributeTypes resolves the value types of the configured attributes
+func (a *App) AttributeTypes() (map[string]existio_client.ValueType, error) {
+	types := make(map[string]existio_client.ValueType, len(a.Config.Attributes))
+	for _, mapping := range a.Config.Attributes {
+		valueType, err := existio_client.ParseValueType(mapping.ValueType)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q: %v", mapping.Name, err)
+		}
+		types[mapping.Name] = valueType
+	}
+	return types, nil
+}
+
+// ExistScope returns the OAuth2 scope needed to read and write the configured attributes
+func (a *App) ExistScope() string {
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, mapping := range a.Config.Attributes {
+		if seen[mapping.Group] {
+			continue
+		}
+		seen[mapping.Group] = true
+		scopes = append(scopes, mapping.Group+"_read", mapping.Group+"_write")
+	}
+	return strings.Join(scopes, " ")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ihoru/instapaper-to-exist/config"
+	"github.com/ihoru/instapaper-to-exist/existio_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// App holds the configuration and the dependencies the commands work with
+type App struct {
+	Config  *config.Config
+	Storage state.Storage
+	Client  *http.Client // used for Exist.io, Instapaper and article requests
+	DryRun  bool         // skip Exist.io and state writes
+	Command string       // the running subcommand, recorded in the audit log
+
+	pendingAudit []state.AuditEntry
+}
+
+// NewApp creates an App. Without a client, one with the default timeout is used.
+func NewApp(cfg *config.Config, storage state.Storage, client *http.Client) *App {
+	if client == nil {
+		client = existio_client.StartSession()
+	}
+	return &App{
+		Config:  cfg,
+		Storage: storage,
+		Client:  client,
+	}
+}
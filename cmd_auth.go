@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+
+	"github.com/ihoru/instapaper-to-exist/config"
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// runAuth authorizes with Exist.io and Instapaper again, without syncing
+func (a *App) runAuth(args []string) {
+	flags := newFlagSet("auth", "[options]", "Authorize with Exist.io (and Instapaper, when the Full API is configured) again.\nWithout -exist or -instapaper, both services are authorized.")
+	existFlag := flags.Bool("exist", false, "Authorize with Exist.io")
+	instapaperFlag := flags.Bool("instapaper", false, "Log in to Instapaper")
+	refreshFlag := flags.Bool("refresh", false, "Only refresh the Exist.io tokens instead of authorizing from scratch")
+	manualFlag := flags.Bool("manual", false, "Paste the address Exist.io redirects to instead of receiving it on EXIST_OAUTH2_RETURN, for machines without a browser [overrides EXIST_OAUTH2_MODE]")
+	common := a.addCommonFlags(flags)
+	flags.Parse(args)
+	common.apply()
+	if *manualFlag {
+		a.Config.ExistOAuth2Mode = config.OAuth2ModeManual
+	}
+	a.LockState()
+
+	doExist, doInstapaper := *existFlag, *instapaperFlag
+	if !doExist && !doInstapaper {
+		doExist, doInstapaper = true, a.Config.UseInstapaperAPI()
+	}
+	if doInstapaper && !a.Config.UseInstapaperAPI() {
+		log.Fatal("Logging in to Instapaper requires the Instapaper API credentials")
+	}
+
+	sessions, _, _ := state.LoadStates(a.Storage)
+	client := a.Client
+
+	if doExist {
+		auth := a.NewExistAuth(&sessions, client)
+		var err error
+		if *refreshFlag && auth.RefreshToken != "" {
+			err = auth.RefreshTokens()
+		} else {
+			err = auth.Authorize()
+		}
+		if err != nil {
+			log.Fatalf("Failed to authorize with Exist: %v", err)
+		}
+		log.Println("Authorized with Exist")
+	}
+
+	if doInstapaper {
+		sessions.Instapaper = instapaper_client.InstapaperAuth{}
+		if _, err := a.GetInstapaperClient(&sessions, client); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Logged in to Instapaper")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/existio_client"
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// runBackfill imports the archive history, credits every article to the day
+// it was archived and submits the days that received articles to Exist.io
+func (a *App) runBackfill(args []string) {
+	flags := newFlagSet("backfill", "[-csv FILE | -html FILE | -api] [options]", "Import the archive history, credit every article to the day it was archived\nand submit the days that received articles to Exist.io.")
+	csvFlag := flags.String("csv", "", "Import from an Instapaper CSV export")
+	htmlFlag := flags.String("html", "", "Import from an Instapaper HTML export (no dates: articles are only marked as seen)")
+	apiFlag := flags.Bool("api", false, "Import the archive through the Instapaper Full API")
+	chunkFlag := flags.Int("chunk", existio_client.DefaultBatchSize, fmt.Sprintf("Number of values per Exist submission request (%d at most)", existio_client.MaxBatchSize))
+	measureFlag := flags.Bool("measure", false, "Fetch every imported article to count its words")
+	dryRunFlag := flags.Bool("dry-run", false, "Print the planned Exist submission without submitting it or saving state")
+	noLowerFlag := flags.Bool("no-lower", false, "Don't lower values that were raised in Exist, e.g. by manual corrections")
+	common := a.addCommonFlags(flags)
+	flags.Parse(args)
+	common.apply()
+
+	if *chunkFlag <= 0 || *chunkFlag > existio_client.MaxBatchSize {
+		log.Fatalf("Chunk size must be between 1 and %d", existio_client.MaxBatchSize)
+	}
+	a.DryRun = *dryRunFlag
+	a.LockState()
+
+	sources := 0
+	for _, set := range []bool{*csvFlag != "", *htmlFlag != "", *apiFlag} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	sessions, articles, readingStats := state.LoadStates(a.Storage)
+	progress := state.LoadProgress(a.Storage)
+	client := a.Client
+	now := a.Config.Now()
+
+	// Import the archive
+	var instapaper *instapaper_client.Client
+	var imported []state.Article
+	var err error
+	switch {
+	case *csvFlag != "":
+		imported, err = ImportCSV(*csvFlag, now)
+	case *htmlFlag != "":
+		imported, err = ImportHTML(*htmlFlag, now)
+	default:
+		if !a.Config.UseInstapaperAPI() {
+			log.Fatal("Importing through the API requires the Instapaper API credentials")
+		}
+		instapaper, err = a.GetInstapaperClient(&sessions, client)
+		if err == nil {
+			imported, err = a.FetchArchive(instapaper, client, now, true)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to import the archive: %v", err)
+	}
+	log.Printf("Imported %d archived articles", len(imported))
+
+	// Credit new articles to their archive day
+	credited := make(map[string]time.Time)
+	added, undated := 0, 0
+	for _, article := range imported {
+		if _, seen := articles[article.GUID]; seen {
+			continue
+		}
+		if article.PubDate.IsZero() {
+			// Without a date there is no day to credit it to
+			articles[article.GUID] = article
+			undated++
+			continue
+		}
+		article.Day = a.Config.DateOf(article.ArchivedAt())
+		if *measureFlag {
+			if err := a.MeasureArticle(instapaper, client, &article); err != nil {
+				log.Printf("Failed to count words of %s: %v", article.GUID, err)
+			}
+		}
+		articles[article.GUID] = article
+		readingStats[article.Day]++
+		a.AuditArticle(article, readingStats[article.Day])
+		added++
+		credited[article.Day] = a.Config.DayOf(article.ArchivedAt())
+	}
+	log.Printf("Credited %d new articles, marked %d undated articles as seen", added, undated)
+
+	if added == 0 {
+		if !a.DryRun {
+			state.SaveStates(a.Storage, &sessions, &articles, &readingStats)
+			a.FlushAudit()
+		}
+		log.Println("Nothing to submit")
+		return
+	}
+
+	// Submit only the days that received articles: the other days have no
+	// imported data, and submitting them would overwrite Exist with zeros
+	attrs, err := a.ConnectExist(&sessions, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var dates []time.Time
+	for _, date := range credited {
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	reading := &Reading{Articles: articles, ReadingStats: readingStats, Progress: progress}
+	submissions, plan, err := a.BuildSubmissions(attrs, dates, reading)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if a.DryRun {
+		if err := a.PrintPlan(os.Stdout, plan, submissions.Data()); err != nil {
+			log.Fatalf("Failed to print the planned submission: %v", err)
+		}
+		log.Println("Dry run: nothing was submitted to Exist and the state was not saved")
+		return
+	}
+
+	attrs.BatchSize = *chunkFlag
+	data := a.Reconcile(attrs, submissions.Data(), dates, readingStats, *noLowerFlag)
+	log.Printf("Submitting %d values for %d days from %s to %s", len(data), len(dates), dates[0].Format("2006-01-02"), dates[len(dates)-1].Format("2006-01-02"))
+	if err := SubmitInChunks(attrs, data, *chunkFlag); err != nil {
+		// The state is left untouched, so the backfill can simply be rerun
+		log.Fatalf("Failed to submit the backfill: %v", err)
+	}
+
+	state.SaveStates(a.Storage, &sessions, &articles, &readingStats)
+	a.FlushAudit()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackfillOnlySubmitsCreditedDays(t *testing.T) {
+	exist, existServer := newFakeExist(t)
+	instapaper, instapaperServer := newFakeInstapaper(t)
+	app, storage := newTestApp(t, existServer, instapaperServer)
+	seed(t, storage)
+	archivedAt := time.Now().Add(-5 * 24 * time.Hour)
+	instapaper.archive(1, archivedAt)
+	instapaper.archive(2, archivedAt)
+
+	day := app.Config.DayOf(archivedAt)
+	date := func(days int) string { return day.AddDate(0, 0, days).Format("2006-01-02") }
+	// Days without imported articles keep the values set in Exist
+	exist.set("articles_read", date(1), 3)
+	exist.set("articles_read", date(4), 1)
+
+	app.runBackfill([]string{"-api"})
+
+	submitted := exist.submitted("articles_read")
+	if len(submitted) != 1 || submitted[date(0)] != 2.0 {
+		t.Errorf("submitted %v, want only 2 on %s", submitted, date(0))
+	}
+	readingStats, _ := storage.LoadStats()
+	if readingStats[date(0)] != 2 {
+		t.Errorf("stats %v, want 2 articles on %s", readingStats, date(0))
+	}
+}
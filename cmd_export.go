@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// runExport writes the stored articles and stats as JSON or CSV
+func (a *App) runExport(args []string) {
+	flags := newFlagSet("export", "[options]", "Export the stored articles and stats. JSON contains everything, CSV one table\nselected with -data.")
+	formatFlag := flags.String("format", "json", "Output format: json or csv")
+	dataFlag := flags.String("data", "articles", "Table to export as CSV: articles or stats")
+	outputFlag := flags.String("o", "", "Write to this file instead of the standard output")
+	common := a.addCommonFlags(flags)
+	flags.Parse(args)
+	common.apply()
+	a.PrepareState()
+
+	_, articles, readingStats := state.LoadStates(a.Storage)
+	progress := state.LoadProgress(a.Storage)
+
+	var out io.Writer = os.Stdout
+	if *outputFlag != "" {
+		file, err := os.Create(*outputFlag)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *outputFlag, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var err error
+	switch *formatFlag {
+	case "json":
+		err = a.exportJSON(out, articles, readingStats, progress)
+	case "csv":
+		switch *dataFlag {
+		case "articles":
+			err = a.exportArticlesCSV(out, articles)
+		case "stats":
+			err = exportStatsCSV(out, readingStats)
+		default:
+			log.Fatalf("Unknown data %q, expected articles or stats", *dataFlag)
+		}
+	default:
+		log.Fatalf("Unknown format %q, expected json or csv", *formatFlag)
+	}
+	if err != nil {
+		log.Fatalf("Failed to export: %v", err)
+	}
+}
+
+// sortedArticles returns the articles ordered by day, then GUID
+func sortedArticles(articles state.Articles) []state.Article {
+	list := make([]state.Article, 0, len(articles))
+	for _, article := range articles {
+		list = append(list, article)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Day != list[j].Day {
+			return list[i].Day < list[j].Day
+		}
+		return list[i].GUID < list[j].GUID
+	})
+	return list
+}
+
+func (a *App) exportJSON(out io.Writer, articles state.Articles, readingStats state.ReadingStats, progress state.Progress) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Articles  []state.Article     `json:"articles"`
+		Stats     state.ReadingStats  `json:"stats"`
+		Completed state.ProgressStats `json:"progress_completed"`
+		Gained    state.ProgressStats `json:"progress_gained"`
+	}{sortedArticles(articles), readingStats, progress.Completed, progress.Gained})
+}
+
+func (a *App) exportArticlesCSV(out io.Writer, articles state.Articles) error {
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"guid", "title", "link", "day", "pub_date", "seen_at", "folder", "words", "minutes"})
+	for _, article := range sortedArticles(articles) {
+		writer.Write([]string{
+			article.GUID,
+			article.Title,
+			article.Link,
+			article.Day,
+			a.formatTimestamp(article.PubDate),
+			a.formatTimestamp(article.SeenAt),
+			article.Folder,
+			strconv.Itoa(article.Words),
+			strconv.Itoa(article.Minutes),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func exportStatsCSV(out io.Writer, readingStats state.ReadingStats) error {
+	dates := make([]string, 0, len(readingStats))
+	for date := range readingStats {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"date", "count"})
+	for _, date := range dates {
+		writer.Write([]string{date, fmt.Sprint(readingStats[date])})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (a *App) formatTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(a.Config.Location).Format(time.RFC3339)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// runHistory prints the audit log entries about a date or range of dates
+func (a *App) runHistory(args []string) {
+	flags := newFlagSet("history", "[options] [DATE[..DATE]]",
+		"Show the audit log entries about a date or range of dates, explaining how\n"+
+			"their values came about. DATE is YYYY-MM-DD, today or yesterday; without\n"+
+			"it, the whole log is shown.")
+	eventFlag := flags.String("event", "", "Only show entries of this event: article, progress, override, adopt, submission or reset")
+	jsonFlag := flags.Bool("json", false, "Print the entries as JSON Lines")
+	common := a.addCommonFlags(flags)
+	flags.Parse(args)
+	common.apply()
+	a.PrepareState()
+
+	var first, last string
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		from, to, isRange := strings.Cut(flags.Arg(0), "..")
+		firstDay, err := a.parseDay(from)
+		if err != nil {
+			log.Fatal(err)
+		}
+		lastDay := firstDay
+		if isRange {
+			if lastDay, err = a.parseDay(to); err != nil {
+				log.Fatal(err)
+			}
+		}
+		first, last = firstDay.Format("2006-01-02"), lastDay.Format("2006-01-02")
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := a.Storage.LoadAudit()
+	if err != nil {
+		log.Fatalf("Failed to read the audit log: %v", err)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	encoder := json.NewEncoder(os.Stdout)
+	for _, entry := range entries {
+		if *eventFlag != "" && entry.Event != *eventFlag {
+			continue
+		}
+		if first != "" && !entry.Covers(first, last) {
+			continue
+		}
+		if *jsonFlag {
+			if err := encoder.Encode(entry); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n",
+			entry.Time.In(a.Config.Location).Format("2006-01-02 15:04:05"),
+			entry.Command, entry.Event, entry.Date, describeAudit(entry, first, last))
+	}
+	if err := table.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// describeAudit summarizes an audit entry in one line. The values of a
+// submission are limited to the dates between first and last, if given.
+func describeAudit(entry state.AuditEntry, first, last string) string {
+	var description string
+	switch entry.Event {
+	case state.AuditArticle:
+		title := entry.Title
+		if title == "" {
+			title = entry.Article
+		}
+		description = fmt.Sprintf("counted %q (%v -> %v)", title, entry.OldValue, entry.NewValue)
+	case state.AuditProgress:
+		description = fmt.Sprintf("progress of %q %v -> %v", entry.Title, entry.OldValue, entry.NewValue)
+	case state.AuditOverride, state.AuditAdopt:
+		description = fmt.Sprintf("%v -> %v", entry.OldValue, entry.NewValue)
+	case state.AuditSubmission:
+		var values []string
+		for _, submission := range entry.Payload {
+			date, _ := submission["date"].(string)
+			if first != "" && (date < first || date > last) {
+				continue
+			}
+			values = append(values, fmt.Sprintf("%s %v=%v", date, submission["name"], submission["value"]))
+		}
+		description = fmt.Sprintf("status %d: %s", entry.Status, strings.Join(values, ", "))
+	}
+
+	if entry.Message != "" {
+		if description == "" {
+			return entry.Message
+		}
+		description += " (" + entry.Message + ")"
+	}
+	return description
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// runSeed marks every article currently in the archive as already seen,
+// without crediting it to any day or submitting anything to Exist.io
+func (a *App) runSeed(args []string) {
+	flags := newFlagSet("init", "[options]", "Mark every article currently in the archive as already seen, without counting\nit or submitting anything to Exist.io. Run it once before the first sync.")
+	dryRunFlag := flags.Bool("dry-run", false, "Fetch the archive without saving state")
+	common := a.addCommonFlags(flags)
+	flags.Parse(args)
+	common.apply()
+	if *dryRunFlag {
+		a.DryRun = true
+	}
+	a.LockState()
+
+	a.seedArchive()
+}
+
+// seedArchive records the current archive and reading progress as the baseline
+func (a *App) seedArchive() {
+	sessions, articles, readingStats := state.LoadStates(a.Storage)
+	progress := state.LoadProgress(a.Storage)
+	client := a.Client
+	now := a.Config.Now()
+
+	var instapaper *instapaper_client.Client
+	var err error
+	if a.Config.UseInstapaperAPI() {
+		instapaper, err = a.GetInstapaperClient(&sessions, client)
+		if err != nil {
+			log.Fatalf("Failed to get Instapaper session: %v", err)
+		}
+	}
+
+	incoming, err := a.FetchArchive(instapaper, client, now, true)
+	if err != nil {
+		log.Fatalf("Failed to fetch Instapaper archive: %v", err)
+	}
+
+	added := 0
+	for _, article := range incoming {
+		if _, seen := articles[article.GUID]; seen {
+			continue
+		}
+		articles[article.GUID] = article
+		added++
+	}
+	log.Printf("Marked %d articles as seen", added)
+
+	// Start tracking progress from the current values instead of crediting
+	// everything read so far to the first run
+	if a.Config.NeedsProgress() {
+		bookmarks, err := FetchProgressBookmarks(instapaper)
+		if err != nil {
+			log.Fatalf("Failed to fetch Instapaper bookmarks: %v", err)
+		}
+		SeedProgress(&progress, bookmarks, now)
+		log.Printf("Recorded the reading progress of %d bookmarks", len(bookmarks))
+	}
+
+	if a.DryRun {
+		log.Println("Dry run: the state was not saved")
+		return
+	}
+	state.SaveStates(a.Storage, &sessions, &articles, &readingStats)
+	state.SaveProgress(a.Storage, &progress)
+}
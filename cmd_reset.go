@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// runReset removes the selected parts of the stored state
+func (a *App) runReset(args []string) {
+	flags := newFlagSet("reset", "[-articles] [-stats] [-progress] [-sessions] [-all] -yes", "Remove parts of the stored state. Nothing is removed in Exist.io and the\naudit log is kept.")
+	articlesFlag := flags.Bool("articles", false, "Forget the seen articles (the next sync requires init or backfill)")
+	statsFlag := flags.Bool("stats", false, "Forget the per-day article counts")
+	progressFlag := flags.Bool("progress", false, "Forget the reading progress")
+	sessionsFlag := flags.Bool("sessions", false, "Forget the Exist and Instapaper tokens")
+	allFlag := flags.Bool("all", false, "Remove the whole state")
+	yesFlag := flags.Bool("yes", false, "Confirm the removal")
+	common := a.addCommonFlags(flags)
+	flags.Parse(args)
+	common.apply()
+
+	var fileNames []string
+	if *articlesFlag || *allFlag {
+		fileNames = append(fileNames, state.ArticlesFile)
+	}
+	if *statsFlag || *allFlag {
+		fileNames = append(fileNames, state.StatsFile)
+	}
+	if *progressFlag || *allFlag {
+		fileNames = append(fileNames, state.ProgressFile)
+	}
+	if *sessionsFlag || *allFlag {
+		fileNames = append(fileNames, state.SessionsFile)
+	}
+	if len(fileNames) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if !*yesFlag {
+		fmt.Printf("This removes %s from %s.\nRun again with -yes to confirm.\n", strings.Join(fileNames, ", "), a.Storage.Location())
+		os.Exit(1)
+	}
+
+	a.LockState()
+	if err := a.Storage.Remove(fileNames...); err != nil {
+		log.Fatalf("Failed to reset state: %v", err)
+	}
+	log.Printf("Removed %s", strings.Join(fileNames, ", "))
+	a.Audit(state.AuditEntry{Event: state.AuditReset, Message: strings.Join(fileNames, ", ")})
+	a.FlushAudit()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// runSet overrides the article counts of dates and submits just those dates to Exist.io
+func (a *App) runSet(args []string) {
+	flags := newFlagSet("set", "[options] DATE[..DATE]=[+|-]N ...",
+		"Set or adjust the number of articles read on dates and submit just those dates\n"+
+			"to Exist.io. DATE is YYYY-MM-DD, today or yesterday; a signed value (+2, -1)\n"+
+			"adjusts the current count. Examples:\n\n"+
+			"  set 2026-10-01=4\n"+
+			"  set 2026-10-01..2026-10-07=+1 today=-1")
+	var specs overrideFlag
+	flags.Var(&specs, "set", "Set or adjust a day's stats, DATE[..DATE]=[+|-]N (repeatable)")
+	dateFlag := flags.String("date", "", "Date or range to set, DATE[..DATE] (used with -value)")
+	valueFlag := flags.String("value", "", "Value for -date, N, +N or -N")
+	noSubmitFlag := flags.Bool("no-submit", false, "Only update the state, don't submit to Exist")
+	dryRunFlag := flags.Bool("dry-run", false, "Print the planned Exist submission without submitting it or saving state")
+	common := a.addCommonFlags(flags)
+	flags.Parse(args)
+	common.apply()
+	a.DryRun = *dryRunFlag
+	a.LockState()
+
+	if *valueFlag != "" {
+		date := *dateFlag
+		if date == "" {
+			date = "today"
+		}
+		specs = append(specs, date+"="+*valueFlag)
+	}
+	specs = append(specs, flags.Args()...)
+	if len(specs) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := a.CheckOverridable(); err != nil {
+		log.Fatal(err)
+	}
+	overrides, err := a.ParseOverrides(specs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessions, articles, readingStats := state.LoadStates(a.Storage)
+	progress := state.LoadProgress(a.Storage)
+
+	changed := make(map[string]time.Time)
+	for _, override := range overrides {
+		for _, date := range a.ApplyOverride(override, readingStats) {
+			changed[date.Format("2006-01-02")] = date
+		}
+	}
+	var dates []time.Time
+	for _, date := range changed {
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	if *noSubmitFlag {
+		if !a.DryRun {
+			state.SaveStates(a.Storage, nil, nil, &readingStats)
+			a.FlushAudit()
+		}
+		return
+	}
+
+	client := a.Client
+	attrs, err := a.ConnectExist(&sessions, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reading := &Reading{Articles: articles, ReadingStats: readingStats, Progress: progress}
+	submissions, plan, err := a.BuildSubmissions(attrs, dates, reading)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if a.DryRun {
+		if err := a.PrintPlan(os.Stdout, plan, submissions.Data()); err != nil {
+			log.Fatalf("Failed to print the planned submission: %v", err)
+		}
+		log.Println("Dry run: nothing was submitted to Exist and the state was not saved")
+		return
+	}
+
+	if err := attrs.UpdateBatch(submissions.Data()); err != nil {
+		log.Fatalf("Failed to update batch: %v", err)
+	}
+	state.SaveStates(a.Storage, nil, nil, &readingStats)
+	a.FlushAudit()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// runStatus prints the stored state, the tokens and the stats of the last days
+func (a *App) runStatus(args []string) {
+	flags := newFlagSet("status", "[options]", "Show the state directory, the stored tokens and the stats of the last days.")
+	daysFlag := flags.Int("days", 7, "Number of days to show")
+	common := a.addCommonFlags(flags)
+	flags.Parse(args)
+	common.apply()
+	a.PrepareState()
+
+	if *daysFlag <= 0 {
+		log.Fatal("Days must be a positive integer")
+	}
+
+	sessions, articles, readingStats := state.LoadStates(a.Storage)
+	progress := state.LoadProgress(a.Storage)
+
+	fmt.Printf("Stored in: %s\n", a.Storage.Location())
+	if a.Storage.IsNew() {
+		fmt.Printf("State: not initialized, run `%s init` or `%s backfill`\n", os.Args[0], os.Args[0])
+	}
+	fmt.Printf("Articles: %d\n", len(articles))
+
+	if sessions.Exist.RefreshToken == "" {
+		fmt.Println("Exist: not authorized")
+	} else {
+		fmt.Printf("Exist: authorized, tokens refreshed %s\n", sessions.Exist.LastRefresh.In(a.Config.Location).Format("2006-01-02 15:04"))
+	}
+	if a.Config.UseInstapaperAPI() {
+		if sessions.Instapaper.Token == "" {
+			fmt.Println("Instapaper: not logged in")
+		} else {
+			fmt.Println("Instapaper: logged in")
+		}
+	} else {
+		fmt.Println("Instapaper: archive RSS feed")
+	}
+	if a.Config.EncryptSecrets() {
+		fmt.Println("Tokens: encrypted")
+	} else {
+		fmt.Println("Tokens: stored in plaintext, set SECRETS_PASSPHRASE or SECRETS_KEYFILE to encrypt them")
+	}
+
+	fmt.Println()
+	today := a.Config.DayOf(a.Config.Now())
+	reading := &Reading{Articles: articles, ReadingStats: readingStats, Progress: progress}
+	var rows [][]string
+	for i := 0; i < *daysFlag; i++ {
+		date := today.AddDate(0, 0, -i).Format("2006-01-02")
+		row := []string{date}
+		for _, mapping := range a.Config.Attributes {
+			row = append(row, fmt.Sprint(Aggregate(mapping, date, reading)))
+		}
+		rows = append(rows, row)
+	}
+	if err := a.PrintTable(os.Stdout, rows); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// runSync fetches new archived articles and submits the last days to Exist.io
+func (a *App) runSync(args []string) {
+	flags := newFlagSet("sync", "[options]", "Count new archived articles and submit the last days to Exist.io.")
+	daysFlag := flags.Int("days", 3, "Number of days to consider for changing stats")
+	todayValueFlag := flags.Int("today", -1, "Value to set for today's stats [-1 to skip]")
+	yesterdayValueFlag := flags.Int("yesterday", -1, "Value to set for yesterdays's stats [-1 to skip]")
+	var specs overrideFlag
+	flags.Var(&specs, "set", "Set or adjust a day's stats, DATE[..DATE]=[+|-]N (repeatable)")
+	seedFlag := flags.Bool("seed", false, "Mark the articles currently in the archive as seen without counting them, then exit")
+	dryRunFlag := flags.Bool("dry-run", false, "Print the planned Exist submission without submitting it or saving state")
+	noLowerFlag := flags.Bool("no-lower", false, "Don't lower values that were raised in Exist, e.g. by manual corrections")
+	common := a.addCommonFlags(flags)
+	flags.Parse(args)
+	common.apply()
+
+	a.DryRun = *dryRunFlag
+	a.LockState()
+	overrides, err := a.ParseOverrides(specs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(overrides) > 0 || *todayValueFlag >= 0 || *yesterdayValueFlag >= 0 {
+		if err := a.CheckOverridable(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	days := *daysFlag
+	if days <= 0 {
+		log.Fatal("Days must be a positive integer")
+	}
+
+	if *seedFlag {
+		a.seedArchive()
+		return
+	}
+
+	// A brand-new state would credit the whole feed to today
+	if a.Storage.IsNew() {
+		if !a.DryRun {
+			log.Fatalf("No state found in %s. Run `%s init` to mark the current archive as seen, or `%s backfill` to import your history first",
+				a.Storage.Location(), os.Args[0], os.Args[0])
+		}
+		log.Println("Warning: no state found, every article in the archive is counted as new")
+	}
+
+	// Load states
+	sessions, articles, readingStats := state.LoadStates(a.Storage)
+	progress := state.LoadProgress(a.Storage)
+
+	// Initialize HTTP client
+	client := a.Client
+
+	// Get Exist.io attributes client
+	attrs, err := a.ConnectExist(&sessions, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Get Instapaper API client
+	var instapaper *instapaper_client.Client
+	if a.Config.UseInstapaperAPI() {
+		instapaper, err = a.GetInstapaperClient(&sessions, client)
+		if err != nil {
+			log.Fatalf("Failed to get Instapaper session: %v", err)
+		}
+	}
+
+	// Fetch archived articles from Instapaper
+	now := a.Config.Now()
+	incoming, err := a.FetchArchive(instapaper, client, now, false)
+	if err != nil {
+		log.Fatalf("Failed to fetch Instapaper archive: %v", err)
+	}
+
+	// Remember the folders of bookmarks for per-folder attributes
+	if folders := a.Config.Folders(); len(folders) > 0 {
+		if err := TrackFolders(instapaper, &progress, folders); err != nil {
+			log.Fatalf("Failed to fetch Instapaper folders: %v", err)
+		}
+	}
+
+	// Process articles, crediting each one to the day it was archived
+	touchedDays := make(map[string]bool)
+	for _, article := range incoming {
+		if _, seen := articles[article.GUID]; seen {
+			continue
+		}
+		article.Day = a.Config.DateOf(article.ArchivedAt())
+		if article.BookmarkID != 0 {
+			article.Folder = progress.Bookmarks[article.BookmarkID].Folder
+		}
+		if a.Config.NeedsWords() {
+			if err := a.MeasureArticle(instapaper, client, &article); err != nil {
+				log.Printf("Failed to count words of %s: %v", article.GUID, err)
+			}
+		}
+		articles[article.GUID] = article
+		readingStats[article.Day]++
+		touchedDays[article.Day] = true
+		a.AuditArticle(article, readingStats[article.Day])
+	}
+
+	// Track reading progress of unread and archived bookmarks
+	if a.Config.NeedsProgress() {
+		bookmarks, err := FetchProgressBookmarks(instapaper)
+		if err != nil {
+			log.Fatalf("Failed to fetch Instapaper bookmarks: %v", err)
+		}
+		// Progress counting turned on for an existing state starts from the
+		// current values, like init does, instead of crediting everything
+		// read so far to today
+		if ProgressTracked(&progress) {
+			for day := range a.TrackProgress(&progress, bookmarks, now) {
+				touchedDays[day] = true
+			}
+		} else {
+			SeedProgress(&progress, bookmarks, now)
+			log.Printf("Recorded the reading progress of %d bookmarks, changes are counted from now on", len(bookmarks))
+		}
+	}
+
+	// Apply manual overrides
+	if *todayValueFlag >= 0 {
+		overrides = append(overrides, Override{First: a.Config.DayOf(now), Last: a.Config.DayOf(now), Value: *todayValueFlag, Spec: fmt.Sprintf("today=%d", *todayValueFlag)})
+	}
+	if *yesterdayValueFlag >= 0 {
+		yesterday := a.Config.DayOf(now).AddDate(0, 0, -1)
+		overrides = append(overrides, Override{First: yesterday, Last: yesterday, Value: *yesterdayValueFlag, Spec: fmt.Sprintf("yesterday=%d", *yesterdayValueFlag)})
+	}
+	for _, override := range overrides {
+		for _, date := range a.ApplyOverride(override, readingStats) {
+			touchedDays[date.Format("2006-01-02")] = true
+		}
+	}
+
+	// Collect the last days plus older days that received articles, e.g. after missed runs
+	var dates []time.Time
+	currentDay := a.Config.DayOf(now)
+	for i := 0; i < days; i++ {
+		date := currentDay.AddDate(0, 0, -i)
+		dates = append(dates, date)
+		delete(touchedDays, date.Format("2006-01-02"))
+	}
+	var olderDays []string
+	for dateStr := range touchedDays {
+		olderDays = append(olderDays, dateStr)
+	}
+	sort.Strings(olderDays)
+	for _, dateStr := range olderDays {
+		date, err := a.Config.ParseDate(dateStr)
+		if err != nil {
+			continue
+		}
+		dates = append(dates, date)
+	}
+
+	// Prepare data for submission
+	reading := &Reading{Articles: articles, ReadingStats: readingStats, Progress: progress}
+	submissions, plan, err := a.BuildSubmissions(attrs, dates, reading)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if a.DryRun {
+		if err := a.PrintPlan(os.Stdout, plan, submissions.Data()); err != nil {
+			log.Fatalf("Failed to print the planned submission: %v", err)
+		}
+		log.Println("Dry run: nothing was submitted to Exist and the state was not saved")
+		return
+	}
+
+	// Skip values Exist already has
+	data := a.Reconcile(attrs, submissions.Data(), dates, readingStats, *noLowerFlag)
+
+	// Submit data to Exist.io
+	if err := attrs.UpdateBatch(data); err != nil {
+		log.Fatalf("Failed to update batch: %v", err)
+	}
+
+	// Save states
+	state.SaveStates(a.Storage, &sessions, &articles, &readingStats)
+	state.SaveProgress(a.Storage, &progress)
+	a.FlushAudit()
+}
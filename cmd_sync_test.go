@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/config"
+	"github.com/ihoru/instapaper-to-exist/existio_client"
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// fakeExist is an Exist.io API server keeping the attribute values in memory
+type fakeExist struct {
+	t       *testing.T
+	mu      sync.Mutex
+	values  map[string]map[string]interface{} // by attribute name and date
+	updates []map[string]interface{}          // every submitted value, in order
+	// writeOnly refuses to read values, like for tokens without the read scope
+	writeOnly bool
+}
+
+func newFakeExist(t *testing.T) (*fakeExist, *httptest.Server) {
+	exist := &fakeExist{t: t, values: make(map[string]map[string]interface{})}
+	server := httptest.NewServer(exist)
+	t.Cleanup(server.Close)
+	return exist, server
+}
+
+// set stores a value as if it was entered in Exist
+func (e *fakeExist) set(name, date string, value interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.values[name] == nil {
+		e.values[name] = make(map[string]interface{})
+	}
+	e.values[name][date] = value
+}
+
+// submitted returns the values submitted for an attribute by date
+func (e *fakeExist) submitted(name string) map[string]interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	values := make(map[string]interface{})
+	for _, update := range e.updates {
+		if update["name"] == name {
+			values[update["date"].(string)] = update["value"]
+		}
+	}
+	return values
+}
+
+func (e *fakeExist) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer access" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api/2/attributes/acquire/":
+		w.Write([]byte(`{"success":[],"failed":[]}`))
+	case "/api/2/attributes/with-values/":
+		if e.writeOnly {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"detail":"You do not have permission to perform this action."}`))
+			return
+		}
+		query := r.URL.Query()
+		days, _ := strconv.Atoi(query.Get("days"))
+		dateMax, err := time.Parse("2006-01-02", query.Get("date_max"))
+		if err != nil {
+			e.t.Errorf("invalid date_max %q", query.Get("date_max"))
+		}
+		type value struct {
+			Date  string      `json:"date"`
+			Value interface{} `json:"value"`
+		}
+		type result struct {
+			Name   string  `json:"name"`
+			Values []value `json:"values"`
+		}
+		var results []result
+		for _, name := range strings.Split(query.Get("attributes"), ",") {
+			res := result{Name: name}
+			for i := 0; i < days; i++ {
+				date := dateMax.AddDate(0, 0, -i).Format("2006-01-02")
+				res.Values = append(res.Values, value{date, e.values[name][date]})
+			}
+			results = append(results, res)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"next": nil, "results": results})
+	case "/api/2/attributes/update/":
+		var updates []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+			e.t.Errorf("invalid update: %v", err)
+		}
+		for _, update := range updates {
+			name, date := update["name"].(string), update["date"].(string)
+			if e.values[name] == nil {
+				e.values[name] = make(map[string]interface{})
+			}
+			e.values[name][date] = update["value"]
+		}
+		e.updates = append(e.updates, updates...)
+		w.Write([]byte(`{"success":[],"failed":[]}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fakeInstapaper is an Instapaper API server listing the bookmarks of its folders
+type fakeInstapaper struct {
+	mu      sync.Mutex
+	folders map[string][]instapaper_client.Bookmark
+}
+
+func newFakeInstapaper(t *testing.T) (*fakeInstapaper, *httptest.Server) {
+	instapaper := &fakeInstapaper{folders: make(map[string][]instapaper_client.Bookmark)}
+	server := httptest.NewServer(instapaper)
+	t.Cleanup(server.Close)
+	return instapaper, server
+}
+
+// archive adds a bookmark to the archive, read up to archivedAt
+func (i *fakeInstapaper) archive(id int64, archivedAt time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.folders[instapaper_client.FolderArchive] = append(i.folders[instapaper_client.FolderArchive], instapaper_client.Bookmark{
+		BookmarkID:        id,
+		URL:               fmt.Sprintf("https://example.com/%d", id),
+		Title:             fmt.Sprintf("Article %d", id),
+		Time:              archivedAt.Add(-24 * time.Hour).Unix(),
+		Progress:          1,
+		ProgressTimestamp: archivedAt.Unix(),
+	})
+}
+
+func (i *fakeInstapaper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/1/bookmarks/list" {
+		http.NotFound(w, r)
+		return
+	}
+	r.ParseForm()
+	have := make(map[string]bool)
+	for _, id := range strings.Split(r.PostForm.Get("have"), ",") {
+		have[id] = true
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	type typedBookmark struct {
+		Type string `json:"type"`
+		instapaper_client.Bookmark
+	}
+	objects := []interface{}{map[string]string{"type": "meta"}, map[string]string{"type": "user"}}
+	for _, bookmark := range i.folders[r.PostForm.Get("folder_id")] {
+		if !have[strconv.FormatInt(bookmark.BookmarkID, 10)] {
+			objects = append(objects, typedBookmark{"bookmark", bookmark})
+		}
+	}
+	json.NewEncoder(w).Encode(objects)
+}
+
+// hostTransport sends the requests for each host to its test server
+type hostTransport map[string]*httptest.Server
+
+func (t hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	server, ok := t[req.URL.Host]
+	if !ok {
+		return nil, fmt.Errorf("unexpected request to %s", req.URL)
+	}
+	redirected := req.Clone(req.Context())
+	redirected.URL.Scheme = "http"
+	redirected.URL.Host = strings.TrimPrefix(server.URL, "http://")
+	return http.DefaultTransport.RoundTrip(redirected)
+}
+
+// newTestApp returns an App counting archived articles in UTC, with its state
+// in memory and its Exist.io and Instapaper requests sent to the test servers
+func newTestApp(t *testing.T, exist, instapaper *httptest.Server) (*App, *state.MemoryStorage) {
+	t.Helper()
+	cfg := &config.Config{
+		ExistClientID:            "id",
+		ExistClientSecret:        "secret",
+		ExistOAuth2Return:        "http://localhost:9009/",
+		ExistOAuth2Mode:          config.OAuth2ModeCallback,
+		ExistAttributeName:       "Articles read",
+		InstapaperConsumerKey:    "key",
+		InstapaperConsumerSecret: "secret",
+		Location:                 time.UTC,
+		LockTimeout:              time.Second,
+		CountMode:                config.CountModeArchive,
+		ProgressThreshold:        0.9,
+	}
+	cfg.Attributes = cfg.DefaultAttributeMappings()
+
+	storage := state.NewMemoryStorage()
+	storage.SaveSessions(&state.Sessions{
+		Exist:      existio_client.ExistAuth{AccessToken: "access", RefreshToken: "refresh", LastRefresh: time.Now()},
+		Instapaper: instapaper_client.InstapaperAuth{Token: "token", TokenSecret: "token secret"},
+	})
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: hostTransport{"exist.io": exist, "www.instapaper.com": instapaper},
+	}
+	return NewApp(cfg, storage, client), storage
+}
+
+// seed stores articles as seen, so a sync doesn't treat the state as new
+func seed(t *testing.T, storage *state.MemoryStorage, ids ...int64) {
+	t.Helper()
+	articles := make(state.Articles)
+	for _, id := range ids {
+		url := fmt.Sprintf("https://example.com/%d", id)
+		articles[url] = state.Article{GUID: url}
+	}
+	if err := storage.SaveArticles(articles); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncSeedsFirstRun(t *testing.T) {
+	exist, existServer := newFakeExist(t)
+	instapaper, instapaperServer := newFakeInstapaper(t)
+	app, storage := newTestApp(t, existServer, instapaperServer)
+	now := time.Now()
+	instapaper.archive(1, now.Add(-48*time.Hour))
+	instapaper.archive(2, now.Add(-time.Hour))
+
+	app.runSync([]string{"-seed"})
+
+	articles, _ := storage.LoadArticles()
+	readingStats, _ := storage.LoadStats()
+	if len(articles) != 2 || len(readingStats) != 0 {
+		t.Errorf("seeded %d articles and %v, want 2 articles and no stats", len(articles), readingStats)
+	}
+	if len(exist.updates) != 0 {
+		t.Errorf("seeding submitted %v", exist.updates)
+	}
+
+	// The next sync only counts the articles archived since
+	archivedAt := now.Add(-time.Minute)
+	instapaper.archive(3, archivedAt)
+	app.runSync(nil)
+
+	day := app.Config.DateOf(archivedAt)
+	readingStats, _ = storage.LoadStats()
+	if len(readingStats) != 1 || readingStats[day] != 1 {
+		t.Errorf("stats %v, want 1 article on %s", readingStats, day)
+	}
+}
+
+func TestSyncCountsArchiveDay(t *testing.T) {
+	exist, existServer := newFakeExist(t)
+	instapaper, instapaperServer := newFakeInstapaper(t)
+	app, storage := newTestApp(t, existServer, instapaperServer)
+	seed(t, storage, 1)
+	now := time.Now()
+	archivedAt := now.Add(-time.Minute)
+	instapaper.archive(1, now.Add(-72*time.Hour))
+	instapaper.archive(2, archivedAt)
+	instapaper.archive(3, archivedAt)
+
+	app.runSync(nil)
+
+	day := app.Config.DateOf(archivedAt)
+	readingStats, _ := storage.LoadStats()
+	if readingStats[day] != 2 {
+		t.Errorf("stats %v, want 2 articles on %s", readingStats, day)
+	}
+	articles, _ := storage.LoadArticles()
+	if article := articles["https://example.com/2"]; article.Day != day || article.BookmarkID != 2 {
+		t.Errorf("stored %+v, want the article credited to %s", article, day)
+	}
+
+	submitted := exist.submitted("articles_read")
+	if len(submitted) != 3 {
+		t.Errorf("submitted %v, want the last 3 days", submitted)
+	}
+	for date, value := range submitted {
+		want := 0.0
+		if date == day {
+			want = 2
+		}
+		if value != want {
+			t.Errorf("submitted %v for %s, want %v", value, date, want)
+		}
+	}
+
+	audit, _ := storage.LoadAudit()
+	counted := 0
+	for _, entry := range audit {
+		if entry.Event == state.AuditArticle {
+			counted++
+		}
+	}
+	if counted != 2 {
+		t.Errorf("audited %d articles, want 2", counted)
+	}
+}
+
+func TestSyncShiftsDayStart(t *testing.T) {
+	exist, existServer := newFakeExist(t)
+	instapaper, instapaperServer := newFakeInstapaper(t)
+	app, storage := newTestApp(t, existServer, instapaperServer)
+	seed(t, storage)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+	instapaper.archive(1, yesterday.Add(2*time.Hour)) // before the day start, still the day before
+	instapaper.archive(2, yesterday.Add(5*time.Hour))
+
+	app.runSync([]string{"-day-start", "04:00"})
+
+	before := yesterday.AddDate(0, 0, -1).Format("2006-01-02")
+	after := yesterday.Format("2006-01-02")
+	readingStats, _ := storage.LoadStats()
+	if readingStats[before] != 1 || readingStats[after] != 1 {
+		t.Errorf("stats %v, want 1 article on %s and on %s", readingStats, before, after)
+	}
+	submitted := exist.submitted("articles_read")
+	if submitted[before] != 1.0 || submitted[after] != 1.0 {
+		t.Errorf("submitted %v, want 1 on %s and on %s", submitted, before, after)
+	}
+}
+
+func TestSyncReconcilesWithExist(t *testing.T) {
+	exist, existServer := newFakeExist(t)
+	instapaper, instapaperServer := newFakeInstapaper(t)
+	app, storage := newTestApp(t, existServer, instapaperServer)
+	seed(t, storage)
+	archivedAt := time.Now().Add(-time.Minute)
+	instapaper.archive(1, archivedAt)
+
+	day := app.Config.DayOf(archivedAt)
+	date := func(days int) string { return day.AddDate(0, 0, -days).Format("2006-01-02") }
+	storage.SaveStats(state.ReadingStats{date(1): 2})
+	exist.set("articles_read", date(1), 2) // unchanged
+	exist.set("articles_read", date(2), 5) // raised in Exist
+
+	app.runSync([]string{"-days", "5", "-no-lower"})
+
+	submitted := exist.submitted("articles_read")
+	if submitted[date(0)] != 1.0 {
+		t.Errorf("submitted %v, want 1 on %s", submitted, date(0))
+	}
+	for _, skipped := range []string{date(1), date(2)} {
+		if value, ok := submitted[skipped]; ok {
+			t.Errorf("submitted %v on %s, want it skipped", value, skipped)
+		}
+	}
+	readingStats, _ := storage.LoadStats()
+	if readingStats[date(2)] != 5 {
+		t.Errorf("stats %v, want the value of %s adopted from Exist", readingStats, date(2))
+	}
+}
+
+func TestSyncSubmitsEverythingWithoutReadScope(t *testing.T) {
+	exist, existServer := newFakeExist(t)
+	instapaper, instapaperServer := newFakeInstapaper(t)
+	app, storage := newTestApp(t, existServer, instapaperServer)
+	seed(t, storage)
+	exist.writeOnly = true
+	archivedAt := time.Now().Add(-time.Minute)
+	instapaper.archive(1, archivedAt)
+
+	app.runSync(nil)
+
+	day := app.Config.DateOf(archivedAt)
+	submitted := exist.submitted("articles_read")
+	if len(submitted) != 3 || submitted[day] != 1.0 {
+		t.Errorf("submitted %v, want the last 3 days with 1 on %s", submitted, day)
+	}
+}
+
+func TestSyncStartsCountingProgressFromCurrentValues(t *testing.T) {
+	exist, existServer := newFakeExist(t)
+	instapaper, instapaperServer := newFakeInstapaper(t)
+	app, storage := newTestApp(t, existServer, instapaperServer)
+	app.Config.CountMode = config.CountModeProgress
+	app.Config.ExistProgressAttributeName = "Articles read"
+	app.Config.Attributes = app.Config.DefaultAttributeMappings()
+	seed(t, storage, 1, 2)
+	now := time.Now()
+	instapaper.archive(1, now.Add(-72*time.Hour))
+	instapaper.archive(2, now.Add(-time.Minute))
+
+	// The state predates progress counting, so nothing read so far is credited
+	app.runSync(nil)
+
+	progress, _ := storage.LoadProgress()
+	if len(progress.Completed) != 0 || len(progress.Gained) != 0 {
+		t.Errorf("credited %v and %v on the first run", progress.Completed, progress.Gained)
+	}
+	if len(progress.Bookmarks) != 2 || progress.Bookmarks[2].Progress != 1 {
+		t.Errorf("recorded %v, want the progress of both bookmarks", progress.Bookmarks)
+	}
+	for date, value := range exist.submitted("articles_read") {
+		if value != 0.0 {
+			t.Errorf("submitted %v for %s, want 0", value, date)
+		}
+	}
+
+	archivedAt := now.Add(-time.Minute)
+	instapaper.archive(3, archivedAt)
+	app.runSync(nil)
+
+	day := app.Config.DateOf(archivedAt)
+	progress, _ = storage.LoadProgress()
+	if progress.Completed[day] != 1 {
+		t.Errorf("completed %v, want 1 article on %s", progress.Completed, day)
+	}
+	if value := exist.submitted("articles_read")[day]; value != 1.0 {
+		t.Errorf("submitted %v for %s, want 1", value, day)
+	}
+}
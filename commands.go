@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/config"
+)
+
+// command is a subcommand of the program
+type command struct {
+	Name    string
+	Summary string
+	Run     func(a *App, args []string)
+}
+
+// commands lists the subcommands in the order they are shown in the usage
+var commands []command
+
+func init() {
+	commands = []command{
+		{"sync", "Count new archived articles and submit them to Exist.io (default)", (*App).runSync},
+		{"init", "Mark the current archive as seen without counting it", (*App).runSeed},
+		{"backfill", "Import the archive history and submit it to Exist.io", (*App).runBackfill},
+		{"auth", "Authorize with Exist.io and Instapaper again", (*App).runAuth},
+		{"status", "Show the state, tokens and recent stats", (*App).runStatus},
+		{"set", "Set or adjust the stats of dates and submit them to Exist.io", (*App).runSet},
+		{"history", "Show the audit log of a date", (*App).runHistory},
+		{"export", "Export the articles and stats as JSON or CSV", (*App).runExport},
+		{"reset", "Remove parts of the stored state", (*App).runReset},
+		{"help", "Show this help", (*App).runHelp},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [options]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(out, "\nRun `%s <command> -h` for the options of a command.\n", os.Args[0])
+}
+
+func (a *App) runHelp(args []string) {
+	if len(args) > 0 {
+		if cmd := findCommand(args[0]); cmd != nil {
+			cmd.Run(a, []string{"-h"})
+			return
+		}
+	}
+	printUsage()
+}
+
+// newFlagSet creates the flag set of a command with its help text
+func newFlagSet(name, arguments, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n\n", os.Args[0], name, arguments)
+		fmt.Fprintln(flags.Output(), description)
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// commonFlags are the flags shared by every command
+type commonFlags struct {
+	config      *config.Config
+	verbose     *bool
+	tz          *string
+	dayStart    *string
+	lockTimeout *time.Duration
+}
+
+func (a *App) addCommonFlags(flags *flag.FlagSet) *commonFlags {
+	return &commonFlags{
+		config:      a.Config,
+		verbose:     flags.Bool("verbose", false, "Enable verbose logging"),
+		tz:          flags.String("tz", "", "Time zone used to compute dates, e.g. Europe/Berlin [overrides TIME_ZONE]"),
+		dayStart:    flags.String("day-start", "", "Time of day (HH:MM) at which a new day starts [overrides DAY_START]"),
+		lockTimeout: flags.Duration("lock-timeout", a.Config.LockTimeout, "How long to wait for another running instance to release the state [overrides LOCK_TIMEOUT]"),
+	}
+}
+
+// apply sets up logging, the date settings and the lock timeout from the parsed flags
+func (c *commonFlags) apply() {
+	if *c.verbose {
+		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	} else {
+		log.SetFlags(log.Ldate | log.Ltime)
+	}
+
+	if err := c.config.SetTimeZone(*c.tz); err != nil {
+		log.Fatal(err)
+	}
+	if err := c.config.SetDayStart(*c.dayStart); err != nil {
+		log.Fatal(err)
+	}
+	if *c.lockTimeout < 0 {
+		log.Fatal("Lock timeout must not be negative")
+	}
+	c.config.LockTimeout = *c.lockTimeout
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ihoru/instapaper-to-exist/existio_client"
+)
+
+// Aggregations compute an attribute's daily value from the stored article data
+const (
+	AggregateCount            = "count"             // articles archived per day
+	AggregateProgress         = "progress"          // articles whose progress crossed the threshold per day
+	AggregateProgressFraction = "progress_fraction" // progress gained per day, in article equivalents
+	AggregateWords            = "words"             // words of the articles archived per day
+	AggregateMinutes          = "minutes"           // estimated reading minutes of the articles archived per day
+	AggregateFolderPrefix     = "folder:"           // "folder:<title>", articles archived per day out of a folder
+)
+
+// aggregateValueTypes lists the value types that can hold the values of each
+// aggregation. Per-folder aggregations count articles, like count.
+var aggregateValueTypes = map[string][]existio_client.ValueType{
+	AggregateCount:            {existio_client.ValueTypeInteger, existio_client.ValueTypeFloat},
+	AggregateProgress:         {existio_client.ValueTypeInteger, existio_client.ValueTypeFloat},
+	AggregateProgressFraction: {existio_client.ValueTypeFloat},
+	AggregateWords:            {existio_client.ValueTypeInteger, existio_client.ValueTypeFloat},
+	AggregateMinutes:          {existio_client.ValueTypePeriod, existio_client.ValueTypeInteger, existio_client.ValueTypeFloat},
+}
+
+// AttributeMapping declares an Exist.io attribute and how its value is computed
+type AttributeMapping struct {
+	Name      string `json:"name"`
+	Group     string `json:"group"`
+	ValueType string `json:"value_type"`
+	Aggregate string `json:"aggregate"`
+}
+
+// Folder returns the folder title of a per-folder aggregation
+func (m AttributeMapping) Folder() (string, bool) {
+	if !strings.HasPrefix(m.Aggregate, AggregateFolderPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(m.Aggregate, AggregateFolderPrefix), true
+}
+
+// Validate checks that the mapping is complete, uses a known aggregation and
+// a value type that can hold its values
+func (m AttributeMapping) Validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("attribute name is required")
+	}
+	if m.Group == "" {
+		return fmt.Errorf("attribute %q: group is required", m.Name)
+	}
+	if m.ValueType == "" {
+		return fmt.Errorf("attribute %q: value_type is required", m.Name)
+	}
+	valueType, err := existio_client.ParseValueType(m.ValueType)
+	if err != nil {
+		return fmt.Errorf("attribute %q: %v", m.Name, err)
+	}
+
+	aggregate := m.Aggregate
+	if folder, ok := m.Folder(); ok && folder != "" {
+		aggregate = AggregateCount
+	}
+	valueTypes, ok := aggregateValueTypes[aggregate]
+	if !ok {
+		return fmt.Errorf("attribute %q: unknown aggregate %q", m.Name, m.Aggregate)
+	}
+	for _, fits := range valueTypes {
+		if valueType == fits {
+			return nil
+		}
+	}
+	return fmt.Errorf("attribute %q: aggregate %q can't be stored as %s values", m.Name, m.Aggregate, valueType)
+}
+
+// LoadAttributeMappings reads attribute mappings from a JSON file
+func LoadAttributeMappings(path string) ([]AttributeMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attributes file: %v", err)
+	}
+
+	var mappings []AttributeMapping
+	if err := json.Unmarshal(data, &mappings); err != nil {
+		return nil, fmt.Errorf("failed to parse attributes file %s: %v", path, err)
+	}
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("attributes file %s declares no attributes", path)
+	}
+
+	seen := make(map[string]bool)
+	for i, mapping := range mappings {
+		if mapping.Group == "" {
+			mappings[i].Group = "media"
+		}
+		if err := mappings[i].Validate(); err != nil {
+			return nil, fmt.Errorf("attributes file %s: %v", path, err)
+		}
+		if seen[mapping.Name] {
+			return nil, fmt.Errorf("attributes file %s: attribute %q is declared twice", path, mapping.Name)
+		}
+		seen[mapping.Name] = true
+	}
+	return mappings, nil
+}
+
+// DefaultAttributeMappings derives the attribute mappings from the
+// COUNT_MODE and TRACK_WORDS settings
+func (c *Config) DefaultAttributeMappings() []AttributeMapping {
+	var mappings []AttributeMapping
+	switch c.CountMode {
+	case CountModeProgress:
+		mappings = append(mappings, AttributeMapping{c.ExistProgressAttributeName, "media", "integer", AggregateProgress})
+	case CountModeProgressFraction:
+		mappings = append(mappings, AttributeMapping{c.ExistProgressAttributeName, "media", "float", AggregateProgressFraction})
+	default:
+		mappings = append(mappings, AttributeMapping{c.ExistAttributeName, "media", "integer", AggregateCount})
+	}
+	if c.TrackWords {
+		mappings = append(mappings,
+			AttributeMapping{c.ExistWordsAttributeName, "media", "integer", AggregateWords},
+			AttributeMapping{c.ExistMinutesAttributeName, "media", "period", AggregateMinutes},
+		)
+	}
+	return mappings
+}
+
+// hasAggregate reports whether any attribute uses one of the aggregations
+func (c *Config) hasAggregate(aggregates ...string) bool {
+	for _, mapping := range c.Attributes {
+		for _, aggregate := range aggregates {
+			if mapping.Aggregate == aggregate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CountsArticles reports whether any attribute is the number of archived articles
+func (c *Config) CountsArticles() bool {
+	return c.hasAggregate(AggregateCount)
+}
+
+// NeedsProgress reports whether any attribute is derived from reading progress
+func (c *Config) NeedsProgress() bool {
+	return c.hasAggregate(AggregateProgress, AggregateProgressFraction)
+}
+
+// NeedsWords reports whether any attribute is derived from article word counts
+func (c *Config) NeedsWords() bool {
+	return c.hasAggregate(AggregateWords, AggregateMinutes)
+}
+
+// Folders returns the titles of the folders used by per-folder attributes
+func (c *Config) Folders() []string {
+	var folders []string
+	for _, mapping := range c.Attributes {
+		if folder, ok := mapping.Folder(); ok {
+			folders = append(folders, folder)
+		}
+	}
+	return folders
+}
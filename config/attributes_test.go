@@ -0,0 +1,40 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttributeMappingValidate(t *testing.T) {
+	tests := []struct {
+		mapping AttributeMapping
+		err     string
+	}{
+		{AttributeMapping{"Articles read", "media", "integer", AggregateCount}, ""},
+		{AttributeMapping{"Articles read", "media", "float", AggregateCount}, ""},
+		{AttributeMapping{"Articles finished", "media", "integer", AggregateProgress}, ""},
+		{AttributeMapping{"Articles progressed", "media", "float", AggregateProgressFraction}, ""},
+		{AttributeMapping{"Words read", "media", "integer", AggregateWords}, ""},
+		{AttributeMapping{"Minutes reading", "media", "period", AggregateMinutes}, ""},
+		{AttributeMapping{"Minutes reading", "media", "integer", AggregateMinutes}, ""},
+		{AttributeMapping{"Longreads read", "media", "integer", "folder:Longreads"}, ""},
+		{AttributeMapping{"", "media", "integer", AggregateCount}, "name is required"},
+		{AttributeMapping{"Articles read", "", "integer", AggregateCount}, "group is required"},
+		{AttributeMapping{"Articles read", "media", "", AggregateCount}, "value_type is required"},
+		{AttributeMapping{"Articles read", "media", "int", AggregateCount}, `unknown value type "int"`},
+		{AttributeMapping{"Articles read", "media", "integer", "articles"}, `unknown aggregate "articles"`},
+		{AttributeMapping{"Articles read", "media", "integer", "folder:"}, `unknown aggregate "folder:"`},
+		{AttributeMapping{"Articles progressed", "media", "integer", AggregateProgressFraction}, "can't be stored as integer"},
+		{AttributeMapping{"Articles progressed", "media", "boolean", AggregateProgressFraction}, "can't be stored as boolean"},
+		{AttributeMapping{"Articles progressed", "media", "percentage", AggregateProgressFraction}, "can't be stored as percentage"},
+		{AttributeMapping{"Read anything", "media", "boolean", AggregateCount}, "can't be stored as boolean"},
+		{AttributeMapping{"Words read", "media", "period", AggregateWords}, "can't be stored as period"},
+		{AttributeMapping{"Longreads read", "media", "string", "folder:Longreads"}, "can't be stored as string"},
+	}
+	for _, test := range tests {
+		err := test.mapping.Validate()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%+v: got error %v, want %q", test.mapping, err, test.err)
+		}
+	}
+}
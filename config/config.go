@@ -5,6 +5,27 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strconv"
+	"time"
+)
+
+// Count modes select what the daily value submitted to Exist.io measures
+const (
+	CountModeArchive          = "archive"           // articles archived per day
+	CountModeProgress         = "progress"          // articles whose progress crossed the threshold per day
+	CountModeProgressFraction = "progress_fraction" // sum of progress gained per day, in article equivalents
+)
+
+// OAuth2 modes select how the Exist.io authorization code is received
+const (
+	OAuth2ModeCallback = "callback" // a local server at EXIST_OAUTH2_RETURN receives the redirect
+	OAuth2ModeManual   = "manual"   // the redirected address is pasted into the terminal
+)
+
+// State backends select where the state is kept
+const (
+	StateBackendFile   = "file"   // JSON files in the state directory
+	StateBackendSQLite = "sqlite" // an SQLite database
 )
 
 // Config holds all environment settings for the application
@@ -12,8 +33,37 @@ type Config struct {
 	ExistClientID        string
 	ExistClientSecret    string
 	ExistOAuth2Return    string
+	ExistOAuth2Mode      string
+	ExistOAuth2Timeout   time.Duration // how long to wait for the redirect in the callback mode
 	ExistAttributeName   string
 	InstapaperArchiveRSS string
+	// Instapaper Full API credentials, used instead of the RSS feed when set
+	InstapaperConsumerKey    string
+	InstapaperConsumerSecret string
+	InstapaperUsername       string
+	InstapaperPassword       string
+	TimeZone                 string
+	Location                 *time.Location
+	DayStart                 time.Duration
+	LockTimeout              time.Duration // how long to wait for another run to finish
+	StateBackend             string
+	StateDatabase            string // path of the SQLite database, in the state directory by default
+	// Passphrase or keyfile the stored OAuth tokens are encrypted with
+	SecretsPassphrase string
+	SecretsKeyFile    string
+
+	CountMode                  string
+	ProgressThreshold          float64
+	ExistProgressAttributeName string
+
+	TrackWords                bool
+	ReadingWordsPerMinute     int
+	ExistWordsAttributeName   string
+	ExistMinutesAttributeName string
+
+	// Exist.io attributes submitted on every run
+	ExistAttributesFile string
+	Attributes          []AttributeMapping
 }
 
 // LoadConfig loads configuration from environment variables or .env file
@@ -28,17 +78,134 @@ func LoadConfig() (*Config, error) {
 		ExistClientID:        os.Getenv("EXIST_CLIENT_ID"),
 		ExistClientSecret:    os.Getenv("EXIST_CLIENT_SECRET"),
 		ExistOAuth2Return:    os.Getenv("EXIST_OAUTH2_RETURN"),
+		ExistOAuth2Mode:      os.Getenv("EXIST_OAUTH2_MODE"),
 		ExistAttributeName:   os.Getenv("EXIST_ATTRIBUTE_NAME"),
 		InstapaperArchiveRSS: os.Getenv("INSTAPAPER_ARCHIVE_RSS"),
+		Location:             time.Local,
+		LockTimeout:          30 * time.Second,
+		ExistOAuth2Timeout:   5 * time.Minute,
+		StateBackend:         os.Getenv("STATE_BACKEND"),
+		StateDatabase:        os.Getenv("STATE_DB"),
+		SecretsPassphrase:    os.Getenv("SECRETS_PASSPHRASE"),
+		SecretsKeyFile:       os.Getenv("SECRETS_KEYFILE"),
+
+		InstapaperConsumerKey:    os.Getenv("INSTAPAPER_CONSUMER_KEY"),
+		InstapaperConsumerSecret: os.Getenv("INSTAPAPER_CONSUMER_SECRET"),
+		InstapaperUsername:       os.Getenv("INSTAPAPER_USERNAME"),
+		InstapaperPassword:       os.Getenv("INSTAPAPER_PASSWORD"),
+
+		CountMode:                  os.Getenv("COUNT_MODE"),
+		ProgressThreshold:          0.9,
+		ExistProgressAttributeName: os.Getenv("EXIST_PROGRESS_ATTRIBUTE_NAME"),
+
+		ReadingWordsPerMinute:     230,
+		ExistWordsAttributeName:   os.Getenv("EXIST_WORDS_ATTRIBUTE_NAME"),
+		ExistMinutesAttributeName: os.Getenv("EXIST_MINUTES_ATTRIBUTE_NAME"),
+
+		ExistAttributesFile: os.Getenv("EXIST_ATTRIBUTES_FILE"),
+	}
+
+	if err := config.SetTimeZone(os.Getenv("TIME_ZONE")); err != nil {
+		return nil, err
+	}
+	if err := config.SetDayStart(os.Getenv("DAY_START")); err != nil {
+		return nil, err
+	}
+
+	if value := os.Getenv("LOCK_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid LOCK_TIMEOUT %q, expected a duration like 30s or 5m", value)
+		}
+		config.LockTimeout = timeout
+	}
+
+	if value := os.Getenv("EXIST_OAUTH2_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid EXIST_OAUTH2_TIMEOUT %q, expected a duration like 5m", value)
+		}
+		config.ExistOAuth2Timeout = timeout
 	}
 
 	// Set default values
 	if config.ExistOAuth2Return == "" {
 		config.ExistOAuth2Return = "http://localhost:9009/"
 	}
+	if config.ExistOAuth2Mode == "" {
+		config.ExistOAuth2Mode = OAuth2ModeCallback
+	}
+	switch config.ExistOAuth2Mode {
+	case OAuth2ModeCallback, OAuth2ModeManual:
+	default:
+		return nil, fmt.Errorf("invalid EXIST_OAUTH2_MODE %q, expected callback or manual", config.ExistOAuth2Mode)
+	}
 	if config.ExistAttributeName == "" {
 		config.ExistAttributeName = "Articles read"
 	}
+	if config.ExistProgressAttributeName == "" {
+		config.ExistProgressAttributeName = "Articles progressed"
+	}
+	if config.StateBackend == "" {
+		config.StateBackend = StateBackendFile
+	}
+	switch config.StateBackend {
+	case StateBackendFile, StateBackendSQLite:
+	default:
+		return nil, fmt.Errorf("invalid STATE_BACKEND %q, expected file or sqlite", config.StateBackend)
+	}
+	if config.SecretsPassphrase != "" && config.SecretsKeyFile != "" {
+		return nil, fmt.Errorf("SECRETS_PASSPHRASE and SECRETS_KEYFILE are mutually exclusive")
+	}
+	if config.CountMode == "" {
+		config.CountMode = CountModeArchive
+	}
+	switch config.CountMode {
+	case CountModeArchive, CountModeProgress, CountModeProgressFraction:
+	default:
+		return nil, fmt.Errorf("invalid COUNT_MODE %q", config.CountMode)
+	}
+	if value := os.Getenv("PROGRESS_THRESHOLD"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			return nil, fmt.Errorf("invalid PROGRESS_THRESHOLD %q, expected a number in (0, 1]", value)
+		}
+		config.ProgressThreshold = threshold
+	}
+	if value := os.Getenv("TRACK_WORDS"); value != "" {
+		trackWords, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRACK_WORDS %q: %v", value, err)
+		}
+		config.TrackWords = trackWords
+	}
+	if value := os.Getenv("READING_WPM"); value != "" {
+		wpm, err := strconv.Atoi(value)
+		if err != nil || wpm <= 0 {
+			return nil, fmt.Errorf("invalid READING_WPM %q, expected a positive integer", value)
+		}
+		config.ReadingWordsPerMinute = wpm
+	}
+	if config.ExistWordsAttributeName == "" {
+		config.ExistWordsAttributeName = "Words read"
+	}
+	if config.ExistMinutesAttributeName == "" {
+		config.ExistMinutesAttributeName = "Minutes reading"
+	}
+	if config.ExistAttributesFile != "" {
+		config.Attributes, err = LoadAttributeMappings(config.ExistAttributesFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		config.Attributes = config.DefaultAttributeMappings()
+	}
+	if config.NeedsProgress() && !config.UseInstapaperAPI() {
+		return nil, fmt.Errorf("progress-based attributes require the Instapaper API credentials")
+	}
+	if len(config.Folders()) > 0 && !config.UseInstapaperAPI() {
+		return nil, fmt.Errorf("per-folder attributes require the Instapaper API credentials")
+	}
 
 	// Validate required fields
 	var missingVars []string
@@ -48,7 +215,10 @@ func LoadConfig() (*Config, error) {
 	if config.ExistClientSecret == "" {
 		missingVars = append(missingVars, "EXIST_CLIENT_SECRET")
 	}
-	if config.InstapaperArchiveRSS == "" {
+	if config.InstapaperConsumerKey != "" && config.InstapaperConsumerSecret == "" {
+		missingVars = append(missingVars, "INSTAPAPER_CONSUMER_SECRET")
+	}
+	if config.InstapaperArchiveRSS == "" && config.InstapaperConsumerKey == "" {
 		missingVars = append(missingVars, "INSTAPAPER_ARCHIVE_RSS")
 	}
 
@@ -59,6 +229,67 @@ func LoadConfig() (*Config, error) {
 	return config, nil
 }
 
+// UseInstapaperAPI reports whether the Instapaper Full API is configured
+func (c *Config) UseInstapaperAPI() bool {
+	return c.InstapaperConsumerKey != "" && c.InstapaperConsumerSecret != ""
+}
+
+// EncryptSecrets reports whether the stored tokens are encrypted
+func (c *Config) EncryptSecrets() bool {
+	return c.SecretsPassphrase != "" || c.SecretsKeyFile != ""
+}
+
+// SetTimeZone switches date computations to the named IANA time zone.
+// An empty name keeps the host's local time zone.
+func (c *Config) SetTimeZone(name string) error {
+	if name == "" {
+		return nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("invalid time zone %q: %v", name, err)
+	}
+	c.TimeZone = name
+	c.Location = location
+	return nil
+}
+
+// SetDayStart sets the time of day (HH:MM) at which a new day begins.
+// Reading done before the cutoff is credited to the previous day.
+func (c *Config) SetDayStart(value string) error {
+	if value == "" {
+		return nil
+	}
+	cutoff, err := time.Parse("15:04", value)
+	if err != nil {
+		return fmt.Errorf("invalid day start %q, expected HH:MM: %v", value, err)
+	}
+	c.DayStart = time.Duration(cutoff.Hour())*time.Hour + time.Duration(cutoff.Minute())*time.Minute
+	return nil
+}
+
+// Now returns the current time in the configured time zone
+func (c *Config) Now() time.Time {
+	return time.Now().In(c.Location)
+}
+
+// DayOf returns midnight of the day the given moment is credited to,
+// taking the configured time zone and day start into account
+func (c *Config) DayOf(t time.Time) time.Time {
+	local := t.In(c.Location).Add(-c.DayStart)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, c.Location)
+}
+
+// DateOf returns the YYYY-MM-DD date the given moment is credited to
+func (c *Config) DateOf(t time.Time) string {
+	return c.DayOf(t).Format("2006-01-02")
+}
+
+// ParseDate parses a YYYY-MM-DD date in the configured time zone
+func (c *Config) ParseDate(value string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", value, c.Location)
+}
+
 // PrintMissingVarsHelp prints helpful information when required variables are missing
 //
 //goland:noinspection GoUnhandledErrorResult
@@ -73,5 +304,11 @@ func PrintMissingVarsHelp() {
 	fmt.Fprintln(os.Stderr, "EXIST_CLIENT_SECRET=your_client_secret_here")
 	fmt.Fprintln(os.Stderr, "INSTAPAPER_ARCHIVE_RSS=https://instapaper.com/archive/rss/123/XXX")
 	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Or, to use the Instapaper Full API instead of the RSS feed:")
+	fmt.Fprintln(os.Stderr, "INSTAPAPER_CONSUMER_KEY=your_consumer_key_here")
+	fmt.Fprintln(os.Stderr, "INSTAPAPER_CONSUMER_SECRET=your_consumer_secret_here")
+	fmt.Fprintln(os.Stderr, "INSTAPAPER_USERNAME=you@example.com")
+	fmt.Fprintln(os.Stderr, "INSTAPAPER_PASSWORD=your_password_here")
+	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "See the README.md file for detailed setup instructions.")
 }
@@ -11,7 +11,8 @@ import (
 
 const (
 	ExistAPIEndpoint = "https://exist.io/api/2/attributes/"
-	ValueTypeInteger = 0
+	DefaultBatchSize = 20 // values per update request
+	MaxBatchSize     = 35 // the most values Exist accepts in one update request
 )
 
 // Attrs handles attribute operations with the Exist.io API
@@ -19,6 +20,11 @@ type Attrs struct {
 	AccessToken string
 	Timeout     time.Duration
 	Client      *http.Client
+	Location    *time.Location // time zone used to format submission dates
+	BatchSize   int            // values per update request, DefaultBatchSize if 0
+	// OnUpdate, if set, is called after every update request with the
+	// submitted values and the response status (0 if no response arrived)
+	OnUpdate func(data []map[string]interface{}, status int, err error)
 }
 
 // NewAttrs creates a new Attrs instance
@@ -30,6 +36,7 @@ func NewAttrs(accessToken string, timeout time.Duration, client *http.Client) *A
 		AccessToken: accessToken,
 		Timeout:     timeout,
 		Client:      client,
+		Location:    time.Local,
 	}
 }
 
@@ -39,12 +46,12 @@ func (a *Attrs) LabelToAttr(label string) string {
 }
 
 // CreateLabel creates a new attribute label
-func (a *Attrs) CreateLabel(group, label string, valueType int, manual bool) error {
+func (a *Attrs) CreateLabel(group, label string, valueType ValueType, manual bool) error {
 	type createRequest struct {
-		Group     string `json:"group"`
-		Label     string `json:"label"`
-		ValueType int    `json:"value_type"`
-		Manual    bool   `json:"manual"`
+		Group     string    `json:"group"`
+		Label     string    `json:"label"`
+		ValueType ValueType `json:"value_type"`
+		Manual    bool      `json:"manual"`
 	}
 
 	reqData := []createRequest{
@@ -91,7 +98,7 @@ func (a *Attrs) CreateLabel(group, label string, valueType int, manual bool) err
 }
 
 // AcquireLabel acquires an attribute label
-func (a *Attrs) AcquireLabel(group, label string, valueType int, manual bool) error {
+func (a *Attrs) AcquireLabel(group, label string, valueType ValueType, manual bool) error {
 	type acquireRequest struct {
 		Name   string `json:"name"`
 		Manual bool   `json:"manual"`
@@ -204,50 +211,73 @@ func (a *Attrs) ChunkSubmissions(arr []map[string]interface{}, size int) [][]map
 
 // UpdateBatch updates a batch of attributes
 func (a *Attrs) UpdateBatch(data []map[string]interface{}) error {
-	for _, chunk := range a.ChunkSubmissions(data, 20) {
-		jsonData, err := json.Marshal(chunk)
-		if err != nil {
-			return err
+	batchSize := a.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	for _, chunk := range a.ChunkSubmissions(data, batchSize) {
+		status, err := a.update(chunk)
+		if a.OnUpdate != nil {
+			a.OnUpdate(chunk, status, err)
 		}
-
-		req, err := http.NewRequest("POST", fmt.Sprintf("%supdate/", ExistAPIEndpoint), bytes.NewBuffer(jsonData))
 		if err != nil {
 			return err
 		}
+	}
 
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.AccessToken))
-		req.Header.Set("Content-Type", "application/json")
+	return nil
+}
 
-		resp, err := a.Client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == http.StatusOK {
-			continue
-		} else if resp.StatusCode == http.StatusAccepted {
-			var errResp map[string]interface{}
-			if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-				return fmt.Errorf("failed to decode error response: %v", err)
-			}
-			return fmt.Errorf("Exist API: Submission: Some failed to update. %v", errResp)
-		}
+// update submits a single request and returns the response status
+func (a *Attrs) update(chunk []map[string]interface{}) (int, error) {
+	jsonData, err := json.Marshal(chunk)
+	if err != nil {
+		return 0, err
+	}
 
+	req, err := http.NewRequest("POST", fmt.Sprintf("%supdate/", ExistAPIEndpoint), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.AccessToken))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, nil
+	} else if resp.StatusCode == http.StatusAccepted {
 		var errResp map[string]interface{}
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return fmt.Errorf("failed to decode error response: %v", err)
+			return resp.StatusCode, fmt.Errorf("failed to decode error response: %v", err)
 		}
-		return fmt.Errorf("Exist API: Submission: %v", errResp)
+		return resp.StatusCode, fmt.Errorf("Exist API: Submission: Some failed to update. %v", errResp)
 	}
 
-	return nil
+	var errResp map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to decode error response: %v", err)
+	}
+	return resp.StatusCode, fmt.Errorf("Exist API: Submission: %v", errResp)
+}
+
+// FormatDate formats a date the way the Exist.io API expects it
+func (a *Attrs) FormatDate(date time.Time) string {
+	if a.Location != nil {
+		date = date.In(a.Location)
+	}
+	return date.Format("2006-01-02")
 }
 
 // FormatSubmission formats a submission for the Exist.io API
 func (a *Attrs) FormatSubmission(date time.Time, name string, value interface{}) map[string]interface{} {
 	return map[string]interface{}{
-		"date":  date.Format("2006-01-02"),
+		"date":  a.FormatDate(date),
 		"name":  a.LabelToAttr(name),
 		"value": value,
 	}
@@ -1,12 +1,21 @@
 package existio_client
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,44 +26,79 @@ const (
 
 // OAuth2 handles authentication with Exist.io
 type OAuth2 struct {
-	ReturnURL     string
-	ClientID      string
-	ClientSecret  string
-	APIScope      string
-	AccessToken   string
-	RefreshToken  string
-	LastRefresh   time.Time
-	Client        *http.Client
-	Server        *http.Server
-	AuthCompleted chan bool
+	ReturnURL    string
+	ClientID     string
+	ClientSecret string
+	APIScope     string
+	AccessToken  string
+	RefreshToken string
+	LastRefresh  time.Time
+	Client       *http.Client
+	Server       *http.Server
+	// Tokens, if set, stores the tokens whenever they change
+	Tokens TokenStore
+	// CallbackTimeout is how long to wait for the redirect to ReturnURL,
+	// DefaultCallbackTimeout if zero
+	CallbackTimeout time.Duration
+	// Manual makes Authorize ask for the address the browser was redirected
+	// to, or the code in it, instead of receiving it on ReturnURL
+	Manual bool
+	// Input is where the redirected address is read from, os.Stdin by default
+	Input io.Reader
+	// NonInteractive makes Authorize fail with ErrInteractionRequired
+	// instead of waiting for a user who isn't there
+	NonInteractive bool
+
+	state string // state parameter of the running authorization
 }
 
+// ErrInteractionRequired is returned by Authorize when no user can complete the authorization
+var ErrInteractionRequired = errors.New("authorizing with Exist requires a user, but the program is not running interactively")
+
 // NewOAuth2 creates a new OAuth2 instance
 func NewOAuth2(returnURL, clientID, clientSecret, apiScope string, client *http.Client) *OAuth2 {
 	if client == nil {
 		client = StartSession()
 	}
 	return &OAuth2{
-		ReturnURL:     returnURL,
-		ClientID:      clientID,
-		ClientSecret:  clientSecret,
-		APIScope:      apiScope,
-		Client:        client,
-		AuthCompleted: make(chan bool),
+		ReturnURL:    returnURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		APIScope:     apiScope,
+		Client:       client,
 	}
 }
 
 // Authorize initiates the OAuth2 authorization flow
 func (o *OAuth2) Authorize() error {
+	if o.NonInteractive {
+		return ErrInteractionRequired
+	}
+
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		return fmt.Errorf("failed to generate the state parameter: %v", err)
+	}
+	o.state = hex.EncodeToString(state)
+
 	queryParams := url.Values{
 		"client_id":     {o.ClientID},
 		"response_type": {"code"},
 		"redirect_uri":  {o.ReturnURL},
 		"scope":         {o.APIScope},
+		"state":         {o.state},
 	}
 
 	authURL := fmt.Sprintf("%sauthorize?%s", ExistOAuthEndpoint, queryParams.Encode())
 	fmt.Println("===Login to Exist===")
+	if o.Manual {
+		fmt.Println("On any device, open the following address in your web browser:")
+		fmt.Println(authURL)
+		fmt.Println("")
+		fmt.Printf("After you allow access, the browser is sent to %s, which may fail to load.\n", o.ReturnURL)
+		return o.ReadExistOAuth2Code()
+	}
+
 	fmt.Println("On this device, open the following address in your web browser:")
 	fmt.Println(authURL)
 	fmt.Println("")
@@ -62,49 +106,184 @@ func (o *OAuth2) Authorize() error {
 	return o.AwaitExistOAuth2Tokens()
 }
 
-// AwaitExistOAuth2Tokens starts a local server to receive the OAuth2 callback
+// ReadExistOAuth2Code asks for the address the browser was redirected to,
+// or just the code in it, and exchanges the code for tokens
+func (o *OAuth2) ReadExistOAuth2Code() error {
+	input := o.Input
+	if input == nil {
+		input = os.Stdin
+	}
+
+	fmt.Print("Paste the address from the address bar, or the code in it: ")
+	line, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && (err != io.EOF || strings.TrimSpace(line) == "") {
+		return fmt.Errorf("no authorization code entered")
+	}
+
+	code, err := o.parseCode(strings.TrimSpace(line))
+	if err != nil {
+		return err
+	}
+	return o.GetToken(code)
+}
+
+// parseCode extracts the authorization code from a redirected address, or
+// its query, and checks its state parameter. Anything else is taken as the code.
+func (o *OAuth2) parseCode(input string) (string, error) {
+	if input == "" {
+		return "", fmt.Errorf("no authorization code entered")
+	}
+	if !strings.ContainsAny(input, "?=") {
+		return input, nil
+	}
+
+	rawQuery := input
+	if _, query, found := strings.Cut(input, "?"); found {
+		rawQuery = query
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("invalid address: %v", err)
+	}
+	return o.codeFromQuery(query)
+}
+
+// codeFromQuery returns the authorization code of a redirect to ReturnURL,
+// after checking that it answers the running authorization
+func (o *OAuth2) codeFromQuery(query url.Values) (string, error) {
+	if reason := query.Get("error"); reason != "" {
+		return "", fmt.Errorf("authorization denied: %s", reason)
+	}
+	if query.Get("state") != o.state {
+		return "", fmt.Errorf("the state parameter doesn't match, the address belongs to another authorization attempt")
+	}
+	code := query.Get("code")
+	if code == "" {
+		return "", fmt.Errorf("the address has no code")
+	}
+	return code, nil
+}
+
+// DefaultCallbackTimeout is how long AwaitExistOAuth2Tokens waits for the redirect by default
+const DefaultCallbackTimeout = 5 * time.Minute
+
+// authPage is shown in the browser once the redirect to ReturnURL is handled
+var authPage = template.Must(template.New("auth").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body style="font-family: sans-serif; max-width: 40em; margin: 4em auto">
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+// renderAuthPage writes authPage with the given status
+func renderAuthPage(w http.ResponseWriter, status int, title, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	authPage.Execute(w, struct{ Title, Message string }{title, message})
+}
+
+// AwaitExistOAuth2Tokens starts a local server to receive the OAuth2 callback.
+// Requests that don't carry an answer to the running authorization, such as
+// the browser asking for a favicon or a redirect with a foreign state
+// parameter, are ignored. It gives up after CallbackTimeout.
 func (o *OAuth2) AwaitExistOAuth2Tokens() error {
 	serverURL, err := url.Parse(o.ReturnURL)
 	if err != nil {
 		return fmt.Errorf("invalid return URL: %v", err)
 	}
+	callbackPath := serverURL.Path
+	if callbackPath == "" {
+		callbackPath = "/"
+	}
+	timeout := o.CallbackTimeout
+	if timeout <= 0 {
+		timeout = DefaultCallbackTimeout
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		code := r.URL.Query().Get("code")
-		if code == "" {
-			http.Error(w, "Code not found", http.StatusBadRequest)
-			o.AuthCompleted <- false
-			return
-		}
-
-		w.Write([]byte("OK!\n"))
-		go func() {
-			err := o.GetToken(code)
-			o.AuthCompleted <- err == nil
-		}()
-	})
+	result := make(chan error, 2)
+	handler := o.callbackHandler(callbackPath, result)
 
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", serverURL.Hostname(), serverURL.Port()))
+	if err != nil {
+		return fmt.Errorf("failed to listen for the redirect to %s: %v", o.ReturnURL, err)
+	}
 	o.Server = &http.Server{
-		Addr:    fmt.Sprintf("%s:%s", serverURL.Hostname(), serverURL.Port()),
-		Handler: mux,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
 	}
 
 	go func() {
-		if err := o.Server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-			fmt.Printf("Server error: %v\n", err)
+		if err := o.Server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			result <- fmt.Errorf("server error: %v", err)
 		}
 	}()
 
-	result := <-o.AuthCompleted
-	o.Server.Close()
+	select {
+	case err = <-result:
+	case <-time.After(timeout):
+		err = fmt.Errorf("no authorization received within %v", timeout)
+	}
+
+	// Let the page of the last request reach the browser
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	o.Server.Shutdown(ctx)
 
-	if !result {
-		return fmt.Errorf("authorization failed")
+	if err != nil {
+		return fmt.Errorf("authorization failed: %v", err)
 	}
 	return nil
 }
 
+// callbackHandler handles the redirects to callbackPath. The outcome of the
+// first one that answers the running authorization is sent to result; later
+// ones are refused.
+func (o *OAuth2) callbackHandler(callbackPath string, result chan<- error) http.Handler {
+	var mu sync.Mutex
+	completed := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != callbackPath || !query.Has("code") && !query.Has("error") {
+			http.NotFound(w, r)
+			return
+		}
+		if query.Get("state") != o.state {
+			// Forged or left over from an earlier attempt, keep waiting for the real one
+			renderAuthPage(w, http.StatusBadRequest, "Authorization failed",
+				"This address doesn't belong to the running authorization. Open the address printed in the terminal instead.")
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if completed {
+			renderAuthPage(w, http.StatusConflict, "Authorization failed", "The authorization was already completed.")
+			return
+		}
+		completed = true
+
+		code, err := o.codeFromQuery(query)
+		if err == nil {
+			err = o.GetToken(code)
+		}
+		if err != nil {
+			renderAuthPage(w, http.StatusBadRequest, "Authorization failed", err.Error())
+		} else {
+			renderAuthPage(w, http.StatusOK, "Connected to Exist", "You can close this window and return to the terminal.")
+		}
+		result <- err
+	})
+	return mux
+}
+
 // GetToken exchanges the authorization code for access and refresh tokens
 func (o *OAuth2) GetToken(code string) error {
 	data := url.Values{
@@ -142,6 +321,9 @@ func (o *OAuth2) GetToken(code string) error {
 	o.AccessToken = tokenResp.AccessToken
 	o.RefreshToken = tokenResp.RefreshToken
 	o.LastRefresh = time.Now()
+	if err := o.saveTokens(); err != nil {
+		return fmt.Errorf("failed to store the tokens: %v", err)
+	}
 	return nil
 }
 
@@ -185,6 +367,9 @@ func (o *OAuth2) RefreshTokens() error {
 	o.AccessToken = tokenResp.AccessToken
 	o.RefreshToken = tokenResp.RefreshToken
 	o.LastRefresh = time.Now()
+	if err := o.saveTokens(); err != nil {
+		return fmt.Errorf("failed to store the tokens: %v", err)
+	}
 	return nil
 }
 
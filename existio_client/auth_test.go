@@ -0,0 +1,214 @@
+package existio_client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTokenServer returns an Exist.io server that issues tokens for the code "good"
+func newTokenServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth2/access_token" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.Form.Get("code") != "good" {
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"access","refresh_token":"refresh"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestParseCode(t *testing.T) {
+	auth := NewOAuth2("http://localhost:9009/", "id", "secret", "scope", nil)
+	auth.state = "s1"
+
+	tests := []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{"good", "good", ""},
+		{"http://localhost:9009/?code=good&state=s1", "good", ""},
+		{"http://localhost:9009/?state=s1&code=good", "good", ""},
+		{"code=good&state=s1", "good", ""},
+		{"?code=good&state=s1", "good", ""},
+		{"", "", "no authorization code"},
+		{"http://localhost:9009/?code=good&state=s2", "", "state parameter doesn't match"},
+		{"http://localhost:9009/?code=good", "", "state parameter doesn't match"},
+		{"http://localhost:9009/?state=s1", "", "has no code"},
+		{"http://localhost:9009/?error=access_denied&state=s1", "", "authorization denied: access_denied"},
+		{"http://localhost:9009/?code=%zz", "", "invalid address"},
+	}
+	for _, test := range tests {
+		got, err := auth.parseCode(test.input)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseCode(%q) returned %q, %v, want error %q", test.input, got, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseCode(%q) = %q, %v, want %q", test.input, got, err, test.want)
+		}
+	}
+}
+
+func TestCallbackHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []string // handled in order
+		statuses []int
+		result   string // error sent to result by the last request, "ok" for success, "" for none
+	}{
+		{
+			name:     "authorized",
+			requests: []string{"/callback?code=good&state=s1"},
+			statuses: []int{http.StatusOK},
+			result:   "ok",
+		},
+		{
+			name: "probes are ignored",
+			requests: []string{"/favicon.ico", "/callback", "/callback?state=s1", "/other?code=good&state=s1",
+				"/callback?code=good&state=s1"},
+			statuses: []int{http.StatusNotFound, http.StatusNotFound, http.StatusNotFound, http.StatusNotFound, http.StatusOK},
+			result:   "ok",
+		},
+		{
+			name:     "foreign state is rejected",
+			requests: []string{"/callback?code=good&state=s2", "/callback?code=good"},
+			statuses: []int{http.StatusBadRequest, http.StatusBadRequest},
+		},
+		{
+			name:     "foreign state doesn't end the authorization",
+			requests: []string{"/callback?code=good&state=s2", "/callback?code=good&state=s1"},
+			statuses: []int{http.StatusBadRequest, http.StatusOK},
+			result:   "ok",
+		},
+		{
+			name:     "second callback",
+			requests: []string{"/callback?code=good&state=s1", "/callback?code=good&state=s1"},
+			statuses: []int{http.StatusOK, http.StatusConflict},
+			result:   "ok",
+		},
+		{
+			name:     "denied",
+			requests: []string{"/callback?error=access_denied&state=s1"},
+			statuses: []int{http.StatusBadRequest},
+			result:   "authorization denied",
+		},
+		{
+			name:     "invalid code",
+			requests: []string{"/callback?code=bad&state=s1"},
+			statuses: []int{http.StatusBadRequest},
+			result:   "invalid_grant",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			auth := NewOAuth2("http://localhost:9009/callback", "id", "secret", "scope", newTestClient(t, newTokenServer(t)))
+			auth.state = "s1"
+			result := make(chan error, 2)
+			handler := auth.callbackHandler("/callback", result)
+
+			for i, target := range test.requests {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+				if recorder.Code != test.statuses[i] {
+					t.Errorf("%s answered %d, want %d", target, recorder.Code, test.statuses[i])
+				}
+			}
+
+			select {
+			case err := <-result:
+				switch {
+				case test.result == "":
+					t.Errorf("sent %v, want nothing", err)
+				case test.result == "ok" && err != nil:
+					t.Errorf("sent %v, want success", err)
+				case test.result != "ok" && (err == nil || !strings.Contains(err.Error(), test.result)):
+					t.Errorf("sent %v, want %q", err, test.result)
+				}
+			default:
+				if test.result != "" {
+					t.Errorf("sent nothing, want %q", test.result)
+				}
+			}
+			if len(result) > 0 {
+				t.Errorf("sent %d more results", len(result))
+			}
+			if authorized := auth.AccessToken == "access"; authorized != (test.result == "ok") {
+				t.Errorf("got access token %q", auth.AccessToken)
+			}
+		})
+	}
+}
+
+// freeReturnURL returns a return URL on a local port that is free
+func freeReturnURL(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return fmt.Sprintf("http://%s/callback", listener.Addr())
+}
+
+func TestAwaitExistOAuth2Tokens(t *testing.T) {
+	returnURL := freeReturnURL(t)
+	auth := NewOAuth2(returnURL, "id", "secret", "scope", newTestClient(t, newTokenServer(t)))
+	auth.state = "s1"
+	auth.CallbackTimeout = 5 * time.Second
+
+	done := make(chan error, 1)
+	go func() { done <- auth.AwaitExistOAuth2Tokens() }()
+
+	// The browser asks for a favicon before it follows the redirect
+	client := &http.Client{Timeout: time.Second}
+	for _, target := range []string{"/favicon.ico", "/callback?code=good&state=s1"} {
+		var resp *http.Response
+		var err error
+		for attempt := 0; attempt < 50; attempt++ {
+			if resp, err = client.Get(strings.TrimSuffix(returnURL, "/callback") + target); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if auth.AccessToken != "access" || auth.RefreshToken != "refresh" {
+		t.Errorf("got tokens %q, %q", auth.AccessToken, auth.RefreshToken)
+	}
+}
+
+func TestAwaitExistOAuth2TokensTimeout(t *testing.T) {
+	auth := NewOAuth2(freeReturnURL(t), "id", "secret", "scope", nil)
+	auth.state = "s1"
+	auth.CallbackTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	err := auth.AwaitExistOAuth2Tokens()
+	if err == nil || !strings.Contains(err.Error(), "no authorization received within 100ms") {
+		t.Fatalf("got error %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+}
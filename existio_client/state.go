@@ -6,7 +6,7 @@ import (
 
 // ExistAuth stores authentication data for Exist.io
 type ExistAuth struct {
-	AccessToken  string
-	RefreshToken string
-	LastRefresh  time.Time
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	LastRefresh  time.Time `json:"last_refresh"`
 }
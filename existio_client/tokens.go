@@ -0,0 +1,63 @@
+package existio_client
+
+import (
+	"sync"
+)
+
+// TokenStore persists the tokens of an OAuth2 client. Save is called
+// whenever the tokens change, so a refresh in the middle of a run is never lost.
+type TokenStore interface {
+	Load() (ExistAuth, error)
+	Save(tokens ExistAuth) error
+}
+
+// LoadTokens replaces the tokens with the ones in the token store
+func (o *OAuth2) LoadTokens() error {
+	if o.Tokens == nil {
+		return nil
+	}
+	tokens, err := o.Tokens.Load()
+	if err != nil {
+		return err
+	}
+	o.AccessToken = tokens.AccessToken
+	o.RefreshToken = tokens.RefreshToken
+	o.LastRefresh = tokens.LastRefresh
+	return nil
+}
+
+// saveTokens writes the tokens to the token store, if there is one
+func (o *OAuth2) saveTokens() error {
+	if o.Tokens == nil {
+		return nil
+	}
+	return o.Tokens.Save(ExistAuth{
+		AccessToken:  o.AccessToken,
+		RefreshToken: o.RefreshToken,
+		LastRefresh:  o.LastRefresh,
+	})
+}
+
+// MemoryTokenStore keeps the tokens in memory
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens ExistAuth
+}
+
+// NewMemoryTokenStore creates a MemoryTokenStore holding the given tokens
+func NewMemoryTokenStore(tokens ExistAuth) *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: tokens}
+}
+
+func (m *MemoryTokenStore) Load() (ExistAuth, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tokens, nil
+}
+
+func (m *MemoryTokenStore) Save(tokens ExistAuth) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens = tokens
+	return nil
+}
@@ -0,0 +1,127 @@
+package existio_client
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends every request to a test server instead of exist.io
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	redirected := req.Clone(req.Context())
+	redirected.URL.Scheme = t.target.Scheme
+	redirected.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(redirected)
+}
+
+// newTestClient returns a client talking to server instead of exist.io
+func newTestClient(t *testing.T, server *httptest.Server) *http.Client {
+	t.Helper()
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Timeout: 5 * time.Second, Transport: redirectTransport{target}}
+}
+
+func TestLoadTokens(t *testing.T) {
+	lastRefresh := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	auth := NewOAuth2("http://localhost:9009/", "id", "secret", "scope", nil)
+	auth.Tokens = NewMemoryTokenStore(ExistAuth{AccessToken: "access", RefreshToken: "refresh", LastRefresh: lastRefresh})
+
+	if err := auth.LoadTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if auth.AccessToken != "access" || auth.RefreshToken != "refresh" || !auth.LastRefresh.Equal(lastRefresh) {
+		t.Errorf("loaded %q, %q, %v", auth.AccessToken, auth.RefreshToken, auth.LastRefresh)
+	}
+}
+
+func TestTransportRefreshesRejectedToken(t *testing.T) {
+	var bodies []string
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth2/access_token":
+			refreshes++
+			if err := r.ParseForm(); err != nil || r.Form.Get("refresh_token") != "old-refresh" {
+				t.Errorf("unexpected refresh request %v", r.Form)
+			}
+			w.Write([]byte(`{"access_token":"new-access","refresh_token":"new-refresh"}`))
+		case "/api/2/attributes/update/":
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			if r.Header.Get("Authorization") != "Bearer new-access" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"success":[],"failed":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	store := NewMemoryTokenStore(ExistAuth{AccessToken: "old-access", RefreshToken: "old-refresh"})
+	auth := NewOAuth2("http://localhost:9009/", "id", "secret", "scope", client)
+	auth.Tokens = store
+	if err := auth.LoadTokens(); err != nil {
+		t.Fatal(err)
+	}
+	notified := 0
+	apiClient := NewClient(auth, client, func(*OAuth2) { notified++ })
+
+	req, err := http.NewRequest("POST", ExistAPIEndpoint+"update/", bytes.NewBufferString(`[{"value":1}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d after the retry", resp.StatusCode)
+	}
+	if refreshes != 1 || notified != 1 {
+		t.Errorf("%d refreshes, %d notifications, want 1 each", refreshes, notified)
+	}
+	if len(bodies) != 2 || bodies[1] != `[{"value":1}]` {
+		t.Errorf("bodies sent %q, want the body sent twice", bodies)
+	}
+	saved, _ := store.Load()
+	if saved.AccessToken != "new-access" || saved.RefreshToken != "new-refresh" || saved.LastRefresh.IsZero() {
+		t.Errorf("store holds %+v, want the refreshed tokens", saved)
+	}
+}
+
+func TestTransportWithoutRefreshToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/access_token" {
+			t.Error("refreshed without a refresh token")
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	auth := NewOAuth2("http://localhost:9009/", "id", "secret", "scope", client)
+	auth.AccessToken = "revoked"
+	auth.NonInteractive = true
+
+	resp, err := NewClient(auth, client, nil).Get(ExistAPIEndpoint)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected an error for a rejected token without a refresh token")
+	}
+}
@@ -0,0 +1,107 @@
+package existio_client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Transport authenticates requests to the Exist.io API with the access token
+// of an OAuth2 client. When Exist rejects the token with 401 Unauthorized,
+// for example because it was revoked before it was due for a refresh, the
+// tokens are refreshed and the request is retried once.
+type Transport struct {
+	Auth *OAuth2
+	// Base sends the requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+	// OnRefresh, if set, is called after the tokens were refreshed and saved
+	// to the token store of Auth, before the retried request is sent
+	OnRefresh func(auth *OAuth2)
+
+	mu sync.Mutex // serializes refreshes and guards the tokens of Auth
+}
+
+// NewClient returns an HTTP client for the Exist.io API that authenticates
+// its requests with auth, using the timeout and transport of base
+func NewClient(auth *OAuth2, base *http.Client, onRefresh func(auth *OAuth2)) *http.Client {
+	if base == nil {
+		base = StartSession()
+	}
+	return &http.Client{
+		Timeout:   base.Timeout,
+		Transport: &Transport{Auth: auth, Base: base.Transport, OnRefresh: onRefresh},
+	}
+}
+
+// RoundTrip sends the request with the current access token and retries it
+// once with refreshed tokens if the token is rejected
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	token := t.Auth.AccessToken
+	t.mu.Unlock()
+
+	resp, err := t.base().RoundTrip(withToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// A body that was already sent can only be sent again if it can be recreated
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	token, err = t.refresh(token)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Exist rejected the access token and refreshing it failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	retry := withToken(req, token)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return t.base().RoundTrip(retry)
+}
+
+// refresh refreshes the tokens, unless another request already replaced the
+// rejected token, and returns the new access token
+func (t *Transport) refresh(rejected string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Auth.AccessToken != rejected {
+		return t.Auth.AccessToken, nil
+	}
+	// Refreshing without a refresh token would start an interactive authorization
+	if t.Auth.RefreshToken == "" {
+		return "", errors.New("no refresh token stored")
+	}
+	if err := t.Auth.RefreshTokens(); err != nil {
+		return "", err
+	}
+	if t.OnRefresh != nil {
+		t.OnRefresh(t.Auth)
+	}
+	return t.Auth.AccessToken, nil
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// withToken returns a copy of req authenticated with token
+func withToken(req *http.Request, token string) *http.Request {
+	authenticated := req.Clone(req.Context())
+	authenticated.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return authenticated
+}
@@ -0,0 +1,231 @@
+package existio_client
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValueType is the type of the values an Exist.io attribute holds
+type ValueType int
+
+const (
+	ValueTypeInteger        ValueType = 0 // whole number
+	ValueTypeFloat          ValueType = 1 // decimal number
+	ValueTypeString         ValueType = 2 // text, up to MaxStringLength characters
+	ValueTypePeriod         ValueType = 3 // duration in minutes
+	ValueTypeTimeOfDay      ValueType = 4 // minutes from midnight
+	ValueTypePercentage     ValueType = 5 // fraction between 0.0 and 1.0
+	ValueTypeTimeFromMidday ValueType = 6 // minutes from midday, negative before noon
+	ValueTypeBoolean        ValueType = 7 // 0 or 1
+	ValueTypeScale          ValueType = 8 // whole number from 1 to 9
+)
+
+const (
+	MaxStringLength = 250
+	MinScaleValue   = 1
+	MaxScaleValue   = 9
+)
+
+// valueTypeNames maps value type names to their Exist.io codes
+var valueTypeNames = map[string]ValueType{
+	"integer":          ValueTypeInteger,
+	"float":            ValueTypeFloat,
+	"string":           ValueTypeString,
+	"period":           ValueTypePeriod,
+	"time_of_day":      ValueTypeTimeOfDay,
+	"percentage":       ValueTypePercentage,
+	"time_from_midday": ValueTypeTimeFromMidday,
+	"boolean":          ValueTypeBoolean,
+	"scale":            ValueTypeScale,
+}
+
+// ParseValueType returns the value type for a name such as "integer"
+func ParseValueType(name string) (ValueType, error) {
+	valueType, ok := valueTypeNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown value type %q", name)
+	}
+	return valueType, nil
+}
+
+func (vt ValueType) String() string {
+	for name, valueType := range valueTypeNames {
+		if valueType == vt {
+			return name
+		}
+	}
+	return fmt.Sprintf("ValueType(%d)", int(vt))
+}
+
+// Normalize checks that a value fits the value type and converts it to the
+// form the Exist.io API expects. Durations are accepted for periods and
+// times of day, time.Time values for times of day and bools for booleans.
+func (vt ValueType) Normalize(value interface{}) (interface{}, error) {
+	switch vt {
+	case ValueTypeInteger:
+		return toInteger(vt, value)
+	case ValueTypeFloat:
+		return toFloat(vt, value)
+	case ValueTypeString:
+		str, ok := value.(string)
+		if !ok {
+			return nil, mismatch(vt, value)
+		}
+		if utf8.RuneCountInString(str) > MaxStringLength {
+			return nil, fmt.Errorf("%s value is longer than %d characters", vt, MaxStringLength)
+		}
+		return str, nil
+	case ValueTypePeriod:
+		if duration, ok := value.(time.Duration); ok {
+			value = int64(math.Round(duration.Minutes()))
+		}
+		minutes, err := toInteger(vt, value)
+		if err != nil {
+			return nil, err
+		}
+		return minutes, checkRange(vt, float64(minutes), 0, math.MaxInt32)
+	case ValueTypeTimeOfDay:
+		switch v := value.(type) {
+		case time.Time:
+			value = int64(v.Hour()*60 + v.Minute())
+		case time.Duration:
+			value = int64(v.Minutes())
+		}
+		minutes, err := toInteger(vt, value)
+		if err != nil {
+			return nil, err
+		}
+		return minutes, checkRange(vt, float64(minutes), 0, 24*60-1)
+	case ValueTypeTimeFromMidday:
+		switch v := value.(type) {
+		case time.Time:
+			value = int64(v.Hour()*60 + v.Minute() - 12*60)
+		case time.Duration:
+			value = int64(v.Minutes())
+		}
+		minutes, err := toInteger(vt, value)
+		if err != nil {
+			return nil, err
+		}
+		return minutes, checkRange(vt, float64(minutes), -12*60, 12*60-1)
+	case ValueTypePercentage:
+		fraction, err := toFloat(vt, value)
+		if err != nil {
+			return nil, err
+		}
+		return fraction, checkRange(vt, fraction, 0, 1)
+	case ValueTypeBoolean:
+		if b, ok := value.(bool); ok {
+			if b {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+		flag, err := toInteger(vt, value)
+		if err != nil {
+			return nil, err
+		}
+		return flag, checkRange(vt, float64(flag), 0, 1)
+	case ValueTypeScale:
+		level, err := toInteger(vt, value)
+		if err != nil {
+			return nil, err
+		}
+		return level, checkRange(vt, float64(level), MinScaleValue, MaxScaleValue)
+	}
+	return nil, fmt.Errorf("unknown value type %d", int(vt))
+}
+
+// toInteger accepts any Go integer, or a float without a fractional part
+func toInteger(vt ValueType, value interface{}) (int64, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("%s value %v is out of range", vt, value)
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("%s value %v is not a whole number", vt, value)
+		}
+		return int64(f), nil
+	}
+	return 0, mismatch(vt, value)
+}
+
+// toFloat accepts any Go number
+func toFloat(vt ValueType, value interface{}) (float64, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("%s value %v is not a finite number", vt, value)
+		}
+		return f, nil
+	}
+	return 0, mismatch(vt, value)
+}
+
+func checkRange(vt ValueType, value, min, max float64) error {
+	if value < min || value > max {
+		return fmt.Errorf("%s value %v is out of range [%v, %v]", vt, value, min, max)
+	}
+	return nil
+}
+
+func mismatch(vt ValueType, value interface{}) error {
+	return fmt.Errorf("%T value %v does not match value type %s", value, value, vt)
+}
+
+// Submissions builds a batch of typed attribute values for UpdateBatch,
+// rejecting values that don't match their attribute's value type
+type Submissions struct {
+	attrs *Attrs
+	types map[string]ValueType
+	data  []map[string]interface{}
+}
+
+// NewSubmissions creates a builder for attributes with the given value types,
+// keyed by attribute label or name
+func (a *Attrs) NewSubmissions(types map[string]ValueType) *Submissions {
+	normalized := make(map[string]ValueType, len(types))
+	for label, valueType := range types {
+		normalized[a.LabelToAttr(label)] = valueType
+	}
+	return &Submissions{
+		attrs: a,
+		types: normalized,
+	}
+}
+
+// Add validates a value and appends it to the batch
+func (s *Submissions) Add(date time.Time, name string, value interface{}) error {
+	valueType, ok := s.types[s.attrs.LabelToAttr(name)]
+	if !ok {
+		return fmt.Errorf("attribute %q has no declared value type", name)
+	}
+	normalized, err := valueType.Normalize(value)
+	if err != nil {
+		return fmt.Errorf("attribute %q on %s: %v", name, s.attrs.FormatDate(date), err)
+	}
+	s.data = append(s.data, s.attrs.FormatSubmission(date, name, normalized))
+	return nil
+}
+
+// Data returns the submissions in the form UpdateBatch expects
+func (s *Submissions) Data() []map[string]interface{} {
+	return s.data
+}
@@ -0,0 +1,136 @@
+package existio_client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxValuesDays is the largest number of days Exist.io returns per request
+const MaxValuesDays = 31
+
+// ErrForbidden is returned by GetValues when Exist.io refuses to read the
+// values, e.g. because the token was issued without the read scope
+var ErrForbidden = errors.New("Exist refused to read the attribute values")
+
+// AttributeValues maps attribute names to their values by date (YYYY-MM-DD).
+// Days without data are absent.
+type AttributeValues map[string]map[string]interface{}
+
+// Value returns the value of an attribute on a date, if there is one
+func (v AttributeValues) Value(name, date string) (interface{}, bool) {
+	value, ok := v[name][date]
+	return value, ok
+}
+
+// GetValues fetches the values of the given attributes between two dates, inclusive
+func (a *Attrs) GetValues(labels []string, dateMin, dateMax time.Time) (AttributeValues, error) {
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = a.LabelToAttr(label)
+	}
+
+	values := make(AttributeValues)
+	for _, name := range names {
+		values[name] = make(map[string]interface{})
+	}
+
+	minDate := a.FormatDate(dateMin)
+	for end := dateMax; a.FormatDate(end) >= minDate; end = end.AddDate(0, 0, -MaxValuesDays) {
+		query := url.Values{
+			"attributes": {strings.Join(names, ",")},
+			"days":       {strconv.Itoa(MaxValuesDays)},
+			"date_max":   {a.FormatDate(end)},
+			"limit":      {"100"},
+		}
+		next := fmt.Sprintf("%swith-values/?%s", ExistAPIEndpoint, query.Encode())
+		for next != "" {
+			var err error
+			next, err = a.getValuesPage(next, values, minDate)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+// getValuesPage fetches one page of attribute values and returns the next page URL
+func (a *Attrs) getValuesPage(pageURL string, values AttributeValues, minDate string) (string, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.AccessToken))
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			errResp = map[string]interface{}{"status": resp.StatusCode}
+		}
+		if resp.StatusCode == http.StatusForbidden {
+			return "", fmt.Errorf("%w: %v", ErrForbidden, errResp)
+		}
+		return "", fmt.Errorf("Exist API: Get Values: %v", errResp)
+	}
+
+	var page struct {
+		Next    string `json:"next"`
+		Results []struct {
+			Name   string `json:"name"`
+			Values []struct {
+				Date  string      `json:"date"`
+				Value interface{} `json:"value"`
+			} `json:"values"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return "", fmt.Errorf("failed to decode values response: %v", err)
+	}
+
+	for _, result := range page.Results {
+		if values[result.Name] == nil {
+			values[result.Name] = make(map[string]interface{})
+		}
+		for _, value := range result.Values {
+			if value.Value == nil || value.Date < minDate {
+				continue
+			}
+			values[result.Name][value.Date] = value.Value
+		}
+	}
+	return page.Next, nil
+}
+
+// Number converts a value read from or submitted to Exist.io to a float64,
+// if it is a number
+func Number(value interface{}) (float64, bool) {
+	if number, ok := value.(json.Number); ok {
+		f, err := number.Float64()
+		return f, err == nil
+	}
+	f, err := toFloat(ValueTypeFloat, value)
+	return f, err == nil
+}
+
+// SameValue reports whether a value read from Exist.io equals a submitted value
+func SameValue(remote, local interface{}) bool {
+	remoteNumber, remoteOK := Number(remote)
+	localNumber, localOK := Number(local)
+	if remoteOK && localOK {
+		return math.Abs(remoteNumber-localNumber) < 1e-9
+	}
+	return fmt.Sprint(remote) == fmt.Sprint(local)
+}
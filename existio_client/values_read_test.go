@@ -0,0 +1,52 @@
+package existio_client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetValues(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/2/attributes/with-values/" || r.URL.Query().Get("attributes") != "articles_read" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"next": null, "results": [{"name": "articles_read", "values": [
+			{"date": "2026-10-02", "value": 3},
+			{"date": "2026-10-01", "value": null},
+			{"date": "2026-09-01", "value": 7}
+		]}]}`))
+	}))
+	defer server.Close()
+
+	attrs := NewAttrs("access", 5*time.Second, newTestClient(t, server))
+	attrs.Location = time.UTC
+	values, err := attrs.GetValues([]string{"Articles read"}, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := values.Value("articles_read", "2026-10-02"); !ok || value != 3.0 {
+		t.Errorf("got %v for 2026-10-02, want 3", value)
+	}
+	for _, date := range []string{"2026-10-01", "2026-09-01"} {
+		if value, ok := values.Value("articles_read", date); ok {
+			t.Errorf("got %v for %s, want no value", value, date)
+		}
+	}
+}
+
+func TestGetValuesWithoutReadScope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"detail": "You do not have permission to perform this action."}`))
+	}))
+	defer server.Close()
+
+	attrs := NewAttrs("access", 5*time.Second, newTestClient(t, server))
+	day := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := attrs.GetValues([]string{"Articles read"}, day, day); !errors.Is(err, ErrForbidden) {
+		t.Errorf("got %v, want ErrForbidden", err)
+	}
+}
@@ -0,0 +1,168 @@
+package existio_client
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseValueType(t *testing.T) {
+	tests := []struct {
+		name string
+		want ValueType
+		err  bool
+	}{
+		{"integer", ValueTypeInteger, false},
+		{"float", ValueTypeFloat, false},
+		{"string", ValueTypeString, false},
+		{"period", ValueTypePeriod, false},
+		{"time_of_day", ValueTypeTimeOfDay, false},
+		{"percentage", ValueTypePercentage, false},
+		{"time_from_midday", ValueTypeTimeFromMidday, false},
+		{"boolean", ValueTypeBoolean, false},
+		{"scale", ValueTypeScale, false},
+		{" Integer ", ValueTypeInteger, false},
+		{"int", 0, true},
+		{"", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseValueType(test.name)
+		if (err != nil) != test.err {
+			t.Errorf("ParseValueType(%q) returned error %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseValueType(%q) = %v, want %v", test.name, got, test.want)
+		}
+		if !test.err && strings.TrimSpace(strings.ToLower(test.name)) != got.String() {
+			t.Errorf("%v.String() = %q", got, got.String())
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	noon := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		valueType ValueType
+		value     interface{}
+		want      interface{}
+		err       bool
+	}{
+		{ValueTypeInteger, 3, int64(3), false},
+		{ValueTypeInteger, uint8(3), int64(3), false},
+		{ValueTypeInteger, 3.0, int64(3), false},
+		{ValueTypeInteger, -3, int64(-3), false},
+		{ValueTypeInteger, 3.5, nil, true},
+		{ValueTypeInteger, math.Inf(1), nil, true},
+		{ValueTypeInteger, uint64(math.MaxUint64), nil, true},
+		{ValueTypeInteger, "3", nil, true},
+
+		{ValueTypeFloat, 1.25, 1.25, false},
+		{ValueTypeFloat, 2, 2.0, false},
+		{ValueTypeFloat, float32(0.5), 0.5, false},
+		{ValueTypeFloat, math.NaN(), nil, true},
+		{ValueTypeFloat, "1.25", nil, true},
+
+		{ValueTypeString, "read", "read", false},
+		{ValueTypeString, strings.Repeat("é", MaxStringLength), strings.Repeat("é", MaxStringLength), false},
+		{ValueTypeString, strings.Repeat("é", MaxStringLength+1), nil, true},
+		{ValueTypeString, 3, nil, true},
+
+		{ValueTypePeriod, 90, int64(90), false},
+		{ValueTypePeriod, 90 * time.Minute, int64(90), false},
+		{ValueTypePeriod, 89*time.Minute + 40*time.Second, int64(90), false},
+		{ValueTypePeriod, 0, int64(0), false},
+		{ValueTypePeriod, -1, nil, true},
+		{ValueTypePeriod, "90", nil, true},
+
+		{ValueTypeTimeOfDay, 0, int64(0), false},
+		{ValueTypeTimeOfDay, 1439, int64(1439), false},
+		{ValueTypeTimeOfDay, 1440, nil, true},
+		{ValueTypeTimeOfDay, -1, nil, true},
+		{ValueTypeTimeOfDay, noon.Add(90 * time.Minute), int64(810), false},
+		{ValueTypeTimeOfDay, 7 * time.Hour, int64(420), false},
+		{ValueTypeTimeOfDay, "07:00", nil, true},
+
+		{ValueTypePercentage, 0, 0.0, false},
+		{ValueTypePercentage, 0.5, 0.5, false},
+		{ValueTypePercentage, 1, 1.0, false},
+		{ValueTypePercentage, 1.01, nil, true},
+		{ValueTypePercentage, -0.01, nil, true},
+		{ValueTypePercentage, "50%", nil, true},
+
+		{ValueTypeTimeFromMidday, 0, int64(0), false},
+		{ValueTypeTimeFromMidday, -720, int64(-720), false},
+		{ValueTypeTimeFromMidday, 719, int64(719), false},
+		{ValueTypeTimeFromMidday, 720, nil, true},
+		{ValueTypeTimeFromMidday, -721, nil, true},
+		{ValueTypeTimeFromMidday, noon.Add(-2 * time.Hour), int64(-120), false},
+		{ValueTypeTimeFromMidday, -30 * time.Minute, int64(-30), false},
+
+		{ValueTypeBoolean, true, int64(1), false},
+		{ValueTypeBoolean, false, int64(0), false},
+		{ValueTypeBoolean, 1, int64(1), false},
+		{ValueTypeBoolean, 0.0, int64(0), false},
+		{ValueTypeBoolean, 2, nil, true},
+		{ValueTypeBoolean, "true", nil, true},
+
+		{ValueTypeScale, MinScaleValue, int64(MinScaleValue), false},
+		{ValueTypeScale, MaxScaleValue, int64(MaxScaleValue), false},
+		{ValueTypeScale, 0, nil, true},
+		{ValueTypeScale, 10, nil, true},
+		{ValueTypeScale, 4.5, nil, true},
+		{ValueTypeScale, "5", nil, true},
+
+		{ValueType(9), 1, nil, true},
+	}
+	for _, test := range tests {
+		got, err := test.valueType.Normalize(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("%v.Normalize(%#v) returned error %v", test.valueType, test.value, err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v.Normalize(%#v) = %#v, want %#v", test.valueType, test.value, got, test.want)
+		}
+	}
+}
+
+func TestSubmissions(t *testing.T) {
+	attrs := NewAttrs("access", time.Second, nil)
+	attrs.Location = time.UTC
+	date := time.Date(2026, 10, 1, 23, 0, 0, 0, time.UTC)
+	submissions := attrs.NewSubmissions(map[string]ValueType{
+		"Articles read": ValueTypeInteger,
+		"Reading time":  ValueTypePeriod,
+		"Mood":          ValueTypeScale,
+	})
+
+	tests := []struct {
+		name  string
+		value interface{}
+		err   string
+	}{
+		{"Articles read", 3, ""},
+		{"articles_read", 4.0, ""},
+		{"Reading time", 25 * time.Minute, ""},
+		{"Articles read", 2.5, `attribute "Articles read" on 2026-10-01`},
+		{"Mood", 0, "out of range"},
+		{"Words read", 100, "no declared value type"},
+	}
+	for _, test := range tests {
+		err := submissions.Add(date, test.name, test.value)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Add(%q, %v) returned error %v, want %q", test.name, test.value, err, test.err)
+		}
+	}
+
+	want := []map[string]interface{}{
+		{"date": "2026-10-01", "name": "articles_read", "value": int64(3)},
+		{"date": "2026-10-01", "name": "articles_read", "value": int64(4)},
+		{"date": "2026-10-01", "name": "reading_time", "value": int64(25)},
+	}
+	if got := submissions.Data(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
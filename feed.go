@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// RSS feed structures
+type RSS struct {
+	XMLName xml.Name `xml:"rss"`
+	Channel Channel  `xml:"channel"`
+}
+
+type Channel struct {
+	Items []Item `xml:"item"`
+}
+
+type Item struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+}
+
+// feedDateLayouts lists the date formats seen in RSS feeds, most common first
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+	"Mon, 02 Jan 06 15:04 -0700",
+	"Mon, 02 Jan 06 15:04 MST",
+	time.RFC3339,
+}
+
+// ParseFeedDate parses an RFC 822/RFC 1123 date as found in RSS pubDate elements
+func ParseFeedDate(value string) (time.Time, error) {
+	value = strings.Join(strings.Fields(value), " ")
+	if value == "" {
+		return time.Time{}, fmt.Errorf("empty date")
+	}
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format: %q", value)
+}
+
+// Key returns the identifier used to track the item in state
+func (i Item) Key() string {
+	if guid := strings.TrimSpace(i.GUID); guid != "" {
+		return guid
+	}
+	return strings.TrimSpace(i.Link)
+}
+
+// Article converts the feed item into a state article
+func (i Item) Article(seenAt time.Time) (state.Article, error) {
+	article := state.Article{
+		GUID:        i.Key(),
+		Title:       strings.TrimSpace(i.Title),
+		Link:        strings.TrimSpace(i.Link),
+		Description: strings.TrimSpace(i.Description),
+		SeenAt:      seenAt,
+	}
+	if i.PubDate == "" {
+		return article, nil
+	}
+	pubDate, err := ParseFeedDate(i.PubDate)
+	if err != nil {
+		return article, err
+	}
+	article.PubDate = pubDate
+	return article, nil
+}
+
+// FetchFeed downloads and parses the Instapaper archive RSS feed
+func FetchFeed(client *http.Client, feedURL string) (*RSS, error) {
+	resp, err := client.Get(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Instapaper: Failed to fetch feed! Status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	var rss RSS
+	if err := xml.Unmarshal(body, &rss); err != nil {
+		return nil, fmt.Errorf("failed to parse RSS feed: %v", err)
+	}
+	return &rss, nil
+}
+
+// FeedArticles converts the feed items into state articles
+func FeedArticles(rss *RSS, seenAt time.Time) []state.Article {
+	var articles []state.Article
+	for _, item := range rss.Channel.Items {
+		if item.Key() == "" {
+			continue
+		}
+		article, err := item.Article(seenAt)
+		if err != nil {
+			log.Printf("Failed to parse pubDate of %s: %v", article.GUID, err)
+		}
+		articles = append(articles, article)
+	}
+	return articles
+}
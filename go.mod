@@ -4,6 +4,22 @@ go 1.24
 
 require (
     github.com/ihoru/instapaper-to-exist/existio_client v0.1.0
+    github.com/ihoru/instapaper-to-exist/instapaper_client v0.1.0
     github.com/ihoru/instapaper-to-exist/storage v0.1.0
     github.com/joho/godotenv v1.5.1
+    golang.org/x/term v0.30.0
+    modernc.org/sqlite v1.37.0
+)
+
+require (
+    github.com/dustin/go-humanize v1.0.1 // indirect
+    github.com/google/uuid v1.6.0 // indirect
+    github.com/mattn/go-isatty v0.0.20 // indirect
+    github.com/ncruces/go-strftime v0.1.9 // indirect
+    github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+    golang.org/x/exp v0.0.0-20250305212735-054e65f0b394 // indirect
+    golang.org/x/sys v0.31.0 // indirect
+    modernc.org/libc v1.62.1 // indirect
+    modernc.org/mathutil v1.7.1 // indirect
+    modernc.org/memory v1.9.1 // indirect
 )
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// ImportCSV reads the archived articles from an Instapaper CSV export
+// (columns URL, Title, Selection, Folder, Timestamp)
+func ImportCSV(path string, seenAt time.Time) ([]state.Article, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"url", "folder"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV export has no %q column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var articles []state.Article
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV export: %v", err)
+		}
+		if !strings.EqualFold(field(record, "folder"), "Archive") || field(record, "url") == "" {
+			continue
+		}
+
+		article := state.Article{
+			GUID:   field(record, "url"),
+			Title:  field(record, "title"),
+			Link:   field(record, "url"),
+			SeenAt: seenAt,
+		}
+		if timestamp, err := strconv.ParseInt(field(record, "timestamp"), 10, 64); err == nil && timestamp > 0 {
+			article.PubDate = time.Unix(timestamp, 0)
+		}
+		articles = append(articles, article)
+	}
+	return articles, nil
+}
+
+var (
+	htmlFolderHeading = regexp.MustCompile(`(?is)<h1[^>]*>(.*?)</h1>`)
+	htmlArticleLink   = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]+)"[^>]*>(.*?)</a>`)
+)
+
+// ImportHTML reads the archived articles from an Instapaper HTML export.
+// The HTML export carries no dates, so the articles have no PubDate.
+func ImportHTML(path string, seenAt time.Time) ([]state.Article, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	document := string(data)
+
+	// Each folder is a heading followed by a list of links
+	headings := htmlFolderHeading.FindAllStringSubmatchIndex(document, -1)
+	var articles []state.Article
+	for i, heading := range headings {
+		folder := strings.TrimSpace(html.UnescapeString(document[heading[2]:heading[3]]))
+		if !strings.EqualFold(folder, "Archive") {
+			continue
+		}
+		end := len(document)
+		if i+1 < len(headings) {
+			end = headings[i+1][0]
+		}
+		for _, link := range htmlArticleLink.FindAllStringSubmatch(document[heading[1]:end], -1) {
+			url := html.UnescapeString(link[1])
+			articles = append(articles, state.Article{
+				GUID:   url,
+				Title:  strings.TrimSpace(html.UnescapeString(htmlTags.ReplaceAllString(link[2], ""))),
+				Link:   url,
+				SeenAt: seenAt,
+			})
+		}
+	}
+	return articles, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// GetInstapaperClient initializes and authenticates with the Instapaper Full API
+func (a *App) GetInstapaperClient(sessions *state.Sessions, client *http.Client) (*instapaper_client.Client, error) {
+	instapaper := instapaper_client.NewClient(
+		a.Config.InstapaperConsumerKey,
+		a.Config.InstapaperConsumerSecret,
+		sessions.Instapaper,
+		client,
+	)
+
+	if instapaper.Token == "" {
+		if a.Config.InstapaperUsername == "" {
+			return nil, fmt.Errorf("INSTAPAPER_USERNAME is required to log in to the Instapaper API")
+		}
+		if err := instapaper.Login(a.Config.InstapaperUsername, a.Config.InstapaperPassword); err != nil {
+			return nil, fmt.Errorf("failed to log in to Instapaper: %v", err)
+		}
+		sessions.Instapaper = instapaper.Auth()
+		if !a.DryRun {
+			state.SaveStates(a.Storage, sessions, nil, nil)
+		}
+	}
+
+	return instapaper, nil
+}
+
+// BookmarkArticle converts an Instapaper bookmark into a state article
+func BookmarkArticle(bookmark instapaper_client.Bookmark, seenAt time.Time) state.Article {
+	return state.Article{
+		GUID:        bookmark.URL,
+		Title:       bookmark.Title,
+		Link:        bookmark.URL,
+		Description: bookmark.Description,
+		PubDate:     archivedAt(bookmark),
+		SeenAt:      seenAt,
+		BookmarkID:  bookmark.BookmarkID,
+	}
+}
+
+// archivedAt approximates when a bookmark was archived. The API has no archive
+// timestamp; the last reading progress update is the closest approximation of
+// when reading finished, and the moment it was saved the only one left for a
+// bookmark archived without being opened.
+func archivedAt(bookmark instapaper_client.Bookmark) time.Time {
+	if progressAt := bookmark.ProgressAt(); !progressAt.IsZero() {
+		return progressAt
+	}
+	return bookmark.SavedAt()
+}
+
+// BookmarkArticles converts Instapaper bookmarks into state articles
+func BookmarkArticles(bookmarks []instapaper_client.Bookmark, seenAt time.Time) []state.Article {
+	var articles []state.Article
+	for _, bookmark := range bookmarks {
+		if bookmark.URL == "" {
+			continue
+		}
+		articles = append(articles, BookmarkArticle(bookmark, seenAt))
+	}
+	return articles
+}
+
+// TrackFolders remembers which user folder each bookmark was seen in, so
+// articles archived later can be attributed to it
+func TrackFolders(instapaper *instapaper_client.Client, progress *state.Progress, titles []string) error {
+	folders, err := instapaper.ListFolders()
+	if err != nil {
+		return err
+	}
+
+	for _, title := range titles {
+		var folderID int64
+		for _, folder := range folders {
+			if strings.EqualFold(folder.Title, title) {
+				folderID = folder.FolderID
+				break
+			}
+		}
+		if folderID == 0 {
+			log.Printf("Instapaper folder %q not found", title)
+			continue
+		}
+
+		bookmarks, err := instapaper.ListBookmarks(strconv.FormatInt(folderID, 10), instapaper_client.BookmarksListLimit)
+		if err != nil {
+			return err
+		}
+		for _, bookmark := range bookmarks {
+			tracked := progress.Bookmarks[bookmark.BookmarkID]
+			tracked.Folder = title
+			progress.Bookmarks[bookmark.BookmarkID] = tracked
+		}
+	}
+	return nil
+}
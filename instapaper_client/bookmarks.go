@@ -0,0 +1,190 @@
+package instapaper_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const BookmarksListLimit = 500
+
+// Bookmark is an article saved to Instapaper
+type Bookmark struct {
+	BookmarkID        int64   `json:"bookmark_id"`
+	URL               string  `json:"url"`
+	Title             string  `json:"title"`
+	Description       string  `json:"description"`
+	Hash              string  `json:"hash"`
+	Time              int64   `json:"time"`
+	Starred           string  `json:"starred"`
+	Progress          float64 `json:"progress"`
+	ProgressTimestamp int64   `json:"progress_timestamp"`
+}
+
+// SavedAt returns the moment the bookmark was saved
+func (b Bookmark) SavedAt() time.Time {
+	return unixTime(b.Time)
+}
+
+// ProgressAt returns the moment the reading progress was last updated
+func (b Bookmark) ProgressAt() time.Time {
+	return unixTime(b.ProgressTimestamp)
+}
+
+// Folder is a user-created Instapaper folder
+type Folder struct {
+	FolderID     int64  `json:"folder_id"`
+	Title        string `json:"title"`
+	SyncToMobile int    `json:"sync_to_mobile"`
+	Position     int64  `json:"position"`
+}
+
+// Highlight is a passage highlighted in a bookmark
+type Highlight struct {
+	HighlightID int64  `json:"highlight_id"`
+	BookmarkID  int64  `json:"bookmark_id"`
+	Text        string `json:"text"`
+	Note        string `json:"note"`
+	Position    int    `json:"position"`
+	Time        int64  `json:"time"`
+}
+
+// CreatedAt returns the moment the highlight was made
+func (h Highlight) CreatedAt() time.Time {
+	return unixTime(h.Time)
+}
+
+func unixTime(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0)
+}
+
+// typedObject is used to split the mixed object lists returned by the API
+type typedObject struct {
+	Type string `json:"type"`
+}
+
+// ListBookmarks lists the bookmarks of a folder ("unread", "starred",
+// "archive" or a folder ID), up to limit items (500 at most). The bookmarks
+// whose IDs are in have are left out.
+func (c *Client) ListBookmarks(folderID string, limit int, have ...int64) ([]Bookmark, error) {
+	if limit <= 0 || limit > BookmarksListLimit {
+		limit = BookmarksListLimit
+	}
+	form := url.Values{
+		"folder_id": {folderID},
+		"limit":     {strconv.Itoa(limit)},
+	}
+	if len(have) > 0 {
+		ids := make([]string, len(have))
+		for i, id := range have {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		form.Set("have", strings.Join(ids, ","))
+	}
+
+	body, err := c.post("1/bookmarks/list", form)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []json.RawMessage
+	if err := json.Unmarshal(body, &objects); err != nil {
+		return nil, fmt.Errorf("Instapaper API: Bookmarks: %v", err)
+	}
+
+	var bookmarks []Bookmark
+	for _, raw := range objects {
+		var object typedObject
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return nil, fmt.Errorf("Instapaper API: Bookmarks: %v", err)
+		}
+		if object.Type == "error" {
+			return nil, parseError(http.StatusOK, body)
+		}
+		if object.Type != "bookmark" {
+			continue
+		}
+		var bookmark Bookmark
+		if err := json.Unmarshal(raw, &bookmark); err != nil {
+			return nil, fmt.Errorf("Instapaper API: Bookmarks: %v", err)
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return bookmarks, nil
+}
+
+// ListAllBookmarks lists every bookmark of a folder. A list holds 500
+// bookmarks at most, so it pages through the folder by leaving out the
+// bookmarks it already has, until a page brings no new ones.
+func (c *Client) ListAllBookmarks(folderID string) ([]Bookmark, error) {
+	var bookmarks []Bookmark
+	var have []int64
+	seen := make(map[int64]bool)
+	for {
+		page, err := c.ListBookmarks(folderID, BookmarksListLimit, have...)
+		if err != nil {
+			return nil, err
+		}
+		added := 0
+		for _, bookmark := range page {
+			if seen[bookmark.BookmarkID] {
+				continue
+			}
+			seen[bookmark.BookmarkID] = true
+			bookmarks = append(bookmarks, bookmark)
+			have = append(have, bookmark.BookmarkID)
+			added++
+		}
+		if added == 0 {
+			return bookmarks, nil
+		}
+	}
+}
+
+// ListFolders lists the user-created folders
+func (c *Client) ListFolders() ([]Folder, error) {
+	body, err := c.post("1/folders/list", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []Folder
+	if err := json.Unmarshal(body, &folders); err != nil {
+		return nil, fmt.Errorf("Instapaper API: Folders: %v", err)
+	}
+	return folders, nil
+}
+
+// ListHighlights lists the highlights of a bookmark
+func (c *Client) ListHighlights(bookmarkID int64) ([]Highlight, error) {
+	body, err := c.post(fmt.Sprintf("1.1/bookmarks/%d/highlights", bookmarkID), url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	var highlights []Highlight
+	if err := json.Unmarshal(body, &highlights); err != nil {
+		return nil, fmt.Errorf("Instapaper API: Highlights: %v", err)
+	}
+	return highlights, nil
+}
+
+// GetText returns the processed text-view HTML of a bookmark
+func (c *Client) GetText(bookmarkID int64) (string, error) {
+	form := url.Values{
+		"bookmark_id": {strconv.FormatInt(bookmarkID, 10)},
+	}
+
+	body, err := c.post("1/bookmarks/get_text", form)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
@@ -0,0 +1,105 @@
+package instapaper_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	InstapaperAPIEndpoint = "https://www.instapaper.com/api/"
+	FolderUnread          = "unread"
+	FolderStarred         = "starred"
+	FolderArchive         = "archive"
+)
+
+// Client handles requests to the Instapaper Full API
+type Client struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	Token          string
+	TokenSecret    string
+	BaseURL        string
+	Client         *http.Client
+}
+
+// NewClient creates a new Client instance
+func NewClient(consumerKey, consumerSecret string, auth InstapaperAuth, client *http.Client) *Client {
+	if client == nil {
+		client = StartSession()
+	}
+	return &Client{
+		ConsumerKey:    consumerKey,
+		ConsumerSecret: consumerSecret,
+		Token:          auth.Token,
+		TokenSecret:    auth.TokenSecret,
+		BaseURL:        InstapaperAPIEndpoint,
+		Client:         client,
+	}
+}
+
+// APIError is an error object returned by the Instapaper API
+type APIError struct {
+	StatusCode int
+	ErrorCode  int    `json:"error_code"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	if e.ErrorCode == 0 {
+		return fmt.Sprintf("Instapaper API: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Instapaper API: error %d: %s", e.ErrorCode, e.Message)
+}
+
+// post performs a signed form POST to a versioned API method, e.g. "1/folders/list"
+func (c *Client) post(method string, form url.Values) ([]byte, error) {
+	endpoint := strings.TrimSuffix(c.BaseURL, "/") + "/" + method
+
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := c.signRequest(req, form); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseError(resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// parseError extracts the error object from a failed API response
+func parseError(statusCode int, body []byte) error {
+	apiErr := &APIError{StatusCode: statusCode}
+	var objects []struct {
+		Type string `json:"type"`
+		APIError
+	}
+	if err := json.Unmarshal(body, &objects); err == nil {
+		for _, object := range objects {
+			if object.Type == "error" {
+				apiErr.ErrorCode = object.ErrorCode
+				apiErr.Message = object.Message
+				break
+			}
+		}
+	}
+	return apiErr
+}
@@ -0,0 +1,336 @@
+package instapaper_client
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	testConsumerKey    = "consumer-key"
+	testConsumerSecret = "consumer secret"
+)
+
+// fakeAPI is an Instapaper API server answering each method with a fixed
+// response. It checks the OAuth signature of every request.
+type fakeAPI struct {
+	t           *testing.T
+	tokenSecret string                          // secret the requests must be signed with
+	responses   map[string]func() (int, string) // by method, e.g. "/api/1/folders/list"
+	forms       map[string]url.Values           // form of the last request by method
+}
+
+func newFakeAPI(t *testing.T) (*fakeAPI, *httptest.Server) {
+	api := &fakeAPI{
+		t:         t,
+		responses: make(map[string]func() (int, string)),
+		forms:     make(map[string]url.Values),
+	}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+// respond makes the server answer method with the given status and body
+func (api *fakeAPI) respond(method string, status int, body string) {
+	api.responses[method] = func() (int, string) { return status, body }
+}
+
+func (api *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		api.t.Errorf("%s: invalid form: %v", r.URL.Path, err)
+	}
+	api.forms[r.URL.Path] = r.PostForm
+	if err := api.verifySignature(r); err != nil {
+		api.t.Errorf("%s: %v", r.URL.Path, err)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	respond, ok := api.responses[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	status, body := respond()
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// verifySignature recomputes the HMAC-SHA1 signature of a request as
+// described in RFC 5849 and compares it with the one in its Authorization header
+func (api *fakeAPI) verifySignature(r *http.Request) error {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "OAuth ") {
+		return errors.New("no OAuth Authorization header")
+	}
+	oauthParams := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(header, "OAuth "), ", ") {
+		key, value, _ := strings.Cut(field, "=")
+		value, err := url.QueryUnescape(strings.Trim(value, `"`))
+		if err != nil {
+			return err
+		}
+		oauthParams[key] = value
+	}
+	if oauthParams["oauth_consumer_key"] != testConsumerKey {
+		return errors.New("wrong consumer key")
+	}
+	if oauthParams["oauth_signature_method"] != "HMAC-SHA1" || oauthParams["oauth_nonce"] == "" || oauthParams["oauth_timestamp"] == "" {
+		return errors.New("missing OAuth parameters")
+	}
+
+	encode := func(value string) string {
+		return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+	}
+	var pairs []string
+	for key, value := range oauthParams {
+		if key != "oauth_signature" {
+			pairs = append(pairs, encode(key)+"="+encode(value))
+		}
+	}
+	for key, values := range r.PostForm {
+		for _, value := range values {
+			pairs = append(pairs, encode(key)+"="+encode(value))
+		}
+	}
+	sort.Strings(pairs)
+	baseString := "POST&" + encode("http://"+r.Host+r.URL.Path) + "&" + encode(strings.Join(pairs, "&"))
+
+	mac := hmac.New(sha1.New, []byte(encode(testConsumerSecret)+"&"+encode(api.tokenSecret)))
+	mac.Write([]byte(baseString))
+	if expected := base64.StdEncoding.EncodeToString(mac.Sum(nil)); oauthParams["oauth_signature"] != expected {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// newTestClient returns a client of the fake API, logged in with token
+func newTestClient(server *httptest.Server, token, tokenSecret string) *Client {
+	client := NewClient(testConsumerKey, testConsumerSecret, InstapaperAuth{Token: token, TokenSecret: tokenSecret}, server.Client())
+	client.BaseURL = server.URL + "/api/"
+	return client
+}
+
+func TestLogin(t *testing.T) {
+	api, server := newFakeAPI(t)
+	api.respond("/api/1/oauth/access_token", http.StatusOK, "oauth_token=token&oauth_token_secret=token%20secret")
+
+	// Stale tokens must not be used to sign the xAuth request
+	client := newTestClient(server, "stale", "stale secret")
+	if err := client.Login("reader@example.com", "p@ss word"); err != nil {
+		t.Fatal(err)
+	}
+
+	form := api.forms["/api/1/oauth/access_token"]
+	if form.Get("x_auth_username") != "reader@example.com" || form.Get("x_auth_password") != "p@ss word" || form.Get("x_auth_mode") != "client_auth" {
+		t.Errorf("unexpected xAuth form %v", form)
+	}
+	if auth := client.Auth(); auth.Token != "token" || auth.TokenSecret != "token secret" {
+		t.Errorf("got tokens %+v", auth)
+	}
+
+	// Later requests are signed with the new token secret
+	api.tokenSecret = "token secret"
+	api.respond("/api/1/folders/list", http.StatusOK, "[]")
+	if _, err := client.ListFolders(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"invalid credentials", http.StatusUnauthorized, `[{"type":"error","error_code":1040,"message":"Invalid xAuth credentials."}]`},
+		{"no token", http.StatusOK, "oauth_token_secret=secret"},
+		{"malformed response", http.StatusOK, "%zz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api, server := newFakeAPI(t)
+			api.respond("/api/1/oauth/access_token", test.status, test.body)
+
+			client := newTestClient(server, "", "")
+			if err := client.Login("reader@example.com", "password"); err == nil {
+				t.Fatal("expected an error")
+			}
+			if client.Token != "" || client.TokenSecret != "" {
+				t.Errorf("kept tokens %q, %q after a failed login", client.Token, client.TokenSecret)
+			}
+		})
+	}
+}
+
+func TestListBookmarks(t *testing.T) {
+	api, server := newFakeAPI(t)
+	api.tokenSecret = "secret"
+	api.respond("/api/1/bookmarks/list", http.StatusOK, `[
+		{"type": "meta"},
+		{"type": "user", "user_id": 1, "username": "reader@example.com"},
+		{"type": "bookmark", "bookmark_id": 10, "url": "https://example.com/a", "title": "A",
+		 "time": 1760000000, "progress": 1, "progress_timestamp": 1760003600, "starred": "0"},
+		{"type": "highlight", "highlight_id": 5, "bookmark_id": 10},
+		{"type": "bookmark", "bookmark_id": 11, "url": "https://example.com/b", "title": "B", "time": 1760007200}
+	]`)
+
+	client := newTestClient(server, "token", "secret")
+	bookmarks, err := client.ListBookmarks(FolderArchive, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := api.forms["/api/1/bookmarks/list"]
+	if form.Get("folder_id") != FolderArchive || form.Get("limit") != "500" {
+		t.Errorf("unexpected form %v", form)
+	}
+	if len(bookmarks) != 2 {
+		t.Fatalf("got %d bookmarks, want 2", len(bookmarks))
+	}
+	if b := bookmarks[0]; b.BookmarkID != 10 || b.Title != "A" || b.Progress != 1 || b.ProgressAt().Unix() != 1760003600 {
+		t.Errorf("unexpected first bookmark %+v", b)
+	}
+	if b := bookmarks[1]; b.BookmarkID != 11 || b.SavedAt().Unix() != 1760007200 || !b.ProgressAt().IsZero() {
+		t.Errorf("unexpected second bookmark %+v", b)
+	}
+}
+
+func TestListBookmarksErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		errorCode int
+	}{
+		{"error status", http.StatusBadRequest, `[{"type":"error","error_code":1241,"message":"Invalid folder"}]`, 1241},
+		{"error object", http.StatusOK, `[{"type":"meta"},{"type":"error","error_code":1500,"message":"Unexpected error"}]`, 1500},
+		{"status without error object", http.StatusServiceUnavailable, "Service Unavailable", 0},
+		{"malformed JSON", http.StatusOK, `[{"type":"bookmark",`, -1},
+		{"malformed bookmark", http.StatusOK, `[{"type":"bookmark","bookmark_id":"ten"}]`, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api, server := newFakeAPI(t)
+			api.respond("/api/1/bookmarks/list", test.status, test.body)
+
+			_, err := newTestClient(server, "", "").ListBookmarks(FolderArchive, 10)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var apiErr *APIError
+			if test.errorCode < 0 {
+				if errors.As(err, &apiErr) {
+					t.Errorf("got an API error %v for a malformed response", err)
+				}
+				return
+			}
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want an APIError", err)
+			}
+			if apiErr.StatusCode != test.status || apiErr.ErrorCode != test.errorCode {
+				t.Errorf("got status %d, error code %d", apiErr.StatusCode, apiErr.ErrorCode)
+			}
+		})
+	}
+}
+
+func TestListAllBookmarks(t *testing.T) {
+	api, server := newFakeAPI(t)
+	var haves []string
+	// The fake folder holds three bookmarks and answers with two at most
+	api.responses["/api/1/bookmarks/list"] = func() (int, string) {
+		have := api.forms["/api/1/bookmarks/list"].Get("have")
+		haves = append(haves, have)
+		var page []string
+		for _, id := range []string{"1", "2", "3"} {
+			if len(page) < 2 && !strings.Contains(","+have+",", ","+id+",") {
+				page = append(page, `{"type":"bookmark","bookmark_id":`+id+`,"url":"https://example.com/`+id+`"}`)
+			}
+		}
+		return http.StatusOK, `[{"type":"meta"},` + strings.Join(append(page, `{"type":"user"}`), ",") + `]`
+	}
+
+	bookmarks, err := newTestClient(server, "", "").ListAllBookmarks(FolderArchive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 3 || bookmarks[0].BookmarkID != 1 || bookmarks[2].BookmarkID != 3 {
+		t.Errorf("unexpected bookmarks %+v", bookmarks)
+	}
+	if want := []string{"", "1,2", "1,2,3"}; strings.Join(haves, "|") != strings.Join(want, "|") {
+		t.Errorf("requested with have %q, want %q", haves, want)
+	}
+}
+
+func TestListFolders(t *testing.T) {
+	api, server := newFakeAPI(t)
+	api.respond("/api/1/folders/list", http.StatusOK, `[
+		{"folder_id": 7, "title": "Long reads", "sync_to_mobile": 1, "position": 1},
+		{"folder_id": 8, "title": "Work", "sync_to_mobile": 0, "position": 2}
+	]`)
+
+	folders, err := newTestClient(server, "", "").ListFolders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 2 || folders[0].FolderID != 7 || folders[0].Title != "Long reads" || folders[1].Title != "Work" {
+		t.Errorf("unexpected folders %+v", folders)
+	}
+
+	api.respond("/api/1/folders/list", http.StatusOK, `{"folders": []}`)
+	if _, err := newTestClient(server, "", "").ListFolders(); err == nil {
+		t.Error("expected an error for a malformed response")
+	}
+}
+
+func TestListHighlights(t *testing.T) {
+	api, server := newFakeAPI(t)
+	api.respond("/api/1.1/bookmarks/10/highlights", http.StatusOK, `[
+		{"highlight_id": 1, "bookmark_id": 10, "text": "A passage", "note": "", "position": 0, "time": 1760000000}
+	]`)
+
+	highlights, err := newTestClient(server, "", "").ListHighlights(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(highlights) != 1 || highlights[0].Text != "A passage" || highlights[0].CreatedAt().Unix() != 1760000000 {
+		t.Errorf("unexpected highlights %+v", highlights)
+	}
+
+	api.respond("/api/1.1/bookmarks/10/highlights", http.StatusForbidden, `[{"type":"error","error_code":1041,"message":"Subscription account required"}]`)
+	var apiErr *APIError
+	if _, err := newTestClient(server, "", "").ListHighlights(10); !errors.As(err, &apiErr) || apiErr.ErrorCode != 1041 {
+		t.Errorf("got %v, want error 1041", err)
+	}
+}
+
+func TestGetText(t *testing.T) {
+	api, server := newFakeAPI(t)
+	api.respond("/api/1/bookmarks/get_text", http.StatusOK, "<html><body><p>Text</p></body></html>")
+
+	text, err := newTestClient(server, "", "").GetText(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "<html><body><p>Text</p></body></html>" {
+		t.Errorf("unexpected text %q", text)
+	}
+	if id := api.forms["/api/1/bookmarks/get_text"].Get("bookmark_id"); id != "10" {
+		t.Errorf("requested bookmark %q", id)
+	}
+
+	api.respond("/api/1/bookmarks/get_text", http.StatusBadRequest, `[{"type":"error","error_code":1550,"message":"Error generating text version of this URL"}]`)
+	var apiErr *APIError
+	if _, err := newTestClient(server, "", "").GetText(10); !errors.As(err, &apiErr) || apiErr.ErrorCode != 1550 {
+		t.Errorf("got %v, want error 1550", err)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/ihoru/instapaper-to-exist/instapaper_client
+
+go 1.24
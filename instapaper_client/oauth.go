@@ -0,0 +1,122 @@
+package instapaper_client
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signRequest adds an OAuth 1.0a HMAC-SHA1 Authorization header to a
+// form-encoded request. The form parameters take part in the signature.
+func (c *Client) signRequest(req *http.Request, form url.Values) error {
+	nonce, err := generateNonce()
+	if err != nil {
+		return err
+	}
+
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     c.ConsumerKey,
+		"oauth_nonce":            nonce,
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_version":          "1.0",
+	}
+	if c.Token != "" {
+		oauthParams["oauth_token"] = c.Token
+	}
+
+	var pairs []string
+	for key, value := range oauthParams {
+		pairs = append(pairs, percentEncode(key)+"="+percentEncode(value))
+	}
+	for key, values := range form {
+		for _, value := range values {
+			pairs = append(pairs, percentEncode(key)+"="+percentEncode(value))
+		}
+	}
+	sort.Strings(pairs)
+
+	baseURL := *req.URL
+	baseURL.RawQuery = ""
+	baseURL.Fragment = ""
+	baseString := strings.Join([]string{
+		strings.ToUpper(req.Method),
+		percentEncode(baseURL.String()),
+		percentEncode(strings.Join(pairs, "&")),
+	}, "&")
+
+	signingKey := percentEncode(c.ConsumerSecret) + "&" + percentEncode(c.TokenSecret)
+	mac := hmac.New(sha1.New, []byte(signingKey))
+	mac.Write([]byte(baseString))
+	oauthParams["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	var header []string
+	for key, value := range oauthParams {
+		header = append(header, fmt.Sprintf(`%s="%s"`, percentEncode(key), percentEncode(value)))
+	}
+	sort.Strings(header)
+	req.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+	return nil
+}
+
+// percentEncode encodes a string as required by RFC 5849 section 3.6
+func percentEncode(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+// generateNonce returns a random string for the oauth_nonce parameter
+func generateNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Login exchanges the user's credentials for an access token using xAuth
+func (c *Client) Login(username, password string) error {
+	form := url.Values{
+		"x_auth_username": {username},
+		"x_auth_password": {password},
+		"x_auth_mode":     {"client_auth"},
+	}
+
+	c.Token = ""
+	c.TokenSecret = ""
+	body, err := c.post("1/oauth/access_token", form)
+	if err != nil {
+		return err
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return fmt.Errorf("Instapaper API: xAuth: invalid response: %v", err)
+	}
+	token := values.Get("oauth_token")
+	tokenSecret := values.Get("oauth_token_secret")
+	if token == "" || tokenSecret == "" {
+		return fmt.Errorf("Instapaper API: xAuth: no token in response")
+	}
+
+	c.Token = token
+	c.TokenSecret = tokenSecret
+	return nil
+}
+
+// Auth returns the current tokens for persisting
+func (c *Client) Auth() InstapaperAuth {
+	return InstapaperAuth{
+		Token:       c.Token,
+		TokenSecret: c.TokenSecret,
+	}
+}
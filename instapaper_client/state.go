@@ -0,0 +1,7 @@
+package instapaper_client
+
+// InstapaperAuth stores authentication data for Instapaper
+type InstapaperAuth struct {
+	Token       string `json:"token"`
+	TokenSecret string `json:"token_secret"`
+}
@@ -1,197 +1,297 @@
 package main
 
 import (
-	"encoding/xml"
-	"flag"
+	"errors"
 	"fmt"
 	"github.com/ihoru/instapaper-to-exist/config"
 	"github.com/ihoru/instapaper-to-exist/state"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
+	_ "time/tzdata" // embedded zone database for hosts without one
 
 	"github.com/ihoru/instapaper-to-exist/existio_client"
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
 	"github.com/ihoru/instapaper-to-exist/storage"
+	"golang.org/x/term"
 )
 
-// Global variables
-var (
-	appConfig       *config.Config
-	storageInstance *storage.Storage
-)
-
-// RSS feed structures
-type RSS struct {
-	XMLName xml.Name `xml:"rss"`
-	Channel Channel  `xml:"channel"`
-}
-
-type Channel struct {
-	Items []Item `xml:"item"`
-}
-
-type Item struct {
-	GUID string `xml:"guid"`
+// OpenStorage opens the state backend selected by STATE_BACKEND in the state directory
+func OpenStorage(cfg *config.Config, files *storage.Storage) (state.Storage, error) {
+	if cfg.StateBackend == config.StateBackendSQLite {
+		path := cfg.StateDatabase
+		if path == "" {
+			path = filepath.Join(files.Dir(), state.DatabaseFile)
+		}
+		return state.NewSQLiteStorage(path, files)
+	}
+	return state.NewFileStorage(files), nil
 }
 
-func init() {
-	var err error
-	appConfig, err = config.LoadConfig()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		config.PrintMissingVarsHelp()
-		os.Exit(1)
+// OpenSecrets creates the cipher the stored tokens are encrypted with, from
+// SECRETS_PASSPHRASE or the content of SECRETS_KEYFILE. It returns nil if
+// neither is set.
+func OpenSecrets(cfg *config.Config) (*storage.Cipher, error) {
+	if cfg.SecretsKeyFile != "" {
+		secret, err := os.ReadFile(cfg.SecretsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SECRETS_KEYFILE: %v", err)
+		}
+		return storage.NewCipher(secret)
 	}
-
-	// Initialize storage
-	storageInstance = storage.NewStorage("instapaper-to-exist")
+	if cfg.SecretsPassphrase != "" {
+		return storage.NewCipher([]byte(cfg.SecretsPassphrase))
+	}
+	return nil, nil
 }
 
-// GetExistSession initializes and authenticates with Exist.io
-func GetExistSession(sessions *state.Sessions, client *http.Client) (*existio_client.OAuth2, error) {
+// NewExistAuth creates the Exist.io OAuth2 client with the tokens in
+// sessions. New tokens are written to sessions and saved right away.
+func (a *App) NewExistAuth(sessions *state.Sessions, client *http.Client) *existio_client.OAuth2 {
 	auth := existio_client.NewOAuth2(
-		appConfig.ExistOAuth2Return,
-		appConfig.ExistClientID,
-		appConfig.ExistClientSecret,
-		"media_write",
+		a.Config.ExistOAuth2Return,
+		a.Config.ExistClientID,
+		a.Config.ExistClientSecret,
+		a.ExistScope(),
 		client,
 	)
-
-	if sessions.Exist.RefreshToken != "" {
-		auth.RefreshToken = sessions.Exist.RefreshToken
-	}
-	if sessions.Exist.AccessToken != "" {
-		auth.AccessToken = sessions.Exist.AccessToken
-	}
-	if !sessions.Exist.LastRefresh.IsZero() {
-		auth.LastRefresh = sessions.Exist.LastRefresh
+	auth.Manual = a.Config.ExistOAuth2Mode == config.OAuth2ModeManual
+	auth.CallbackTimeout = a.Config.ExistOAuth2Timeout
+	// Under cron nobody can open the address, so fail instead of waiting forever
+	auth.NonInteractive = !term.IsTerminal(int(os.Stdin.Fd()))
+
+	auth.Tokens = state.NewExistTokenStore(a.Storage, sessions)
+	if err := auth.LoadTokens(); err != nil {
+		log.Printf("Failed to load the Exist tokens: %v", err)
 	}
+	return auth
+}
 
+// GetExistSession initializes and authenticates with Exist.io
+func (a *App) GetExistSession(sessions *state.Sessions, client *http.Client) (*existio_client.OAuth2, error) {
+	auth := a.NewExistAuth(sessions, client)
 	if err := auth.EvaluateTokens(); err != nil {
+		if errors.Is(err, existio_client.ErrInteractionRequired) {
+			return nil, fmt.Errorf("%v. Run `%s auth` in a terminal first, with -manual on a machine without a browser", err, os.Args[0])
+		}
 		return nil, fmt.Errorf("failed to evaluate tokens: %v", err)
 	}
-
-	sessions.Exist.AccessToken = auth.AccessToken
-	sessions.Exist.RefreshToken = auth.RefreshToken
-	sessions.Exist.LastRefresh = auth.LastRefresh
-
-	state.SaveStates(storageInstance, sessions, nil, nil)
 	return auth, nil
 }
 
-// GetExistAttrs initializes the Exist.io attributes client
-func GetExistAttrs(sessions *state.Sessions, client *http.Client) (*existio_client.Attrs, error) {
+// GetExistAttrs initializes the Exist.io attributes client. Its requests
+// refresh the tokens when Exist rejects them.
+func (a *App) GetExistAttrs(sessions *state.Sessions, auth *existio_client.OAuth2, client *http.Client) (*existio_client.Attrs, error) {
 	accessToken := sessions.Exist.AccessToken
 	if accessToken == "" {
 		return nil, fmt.Errorf("access token not found in sessions")
 	}
 
-	attrs := existio_client.NewAttrs(accessToken, 5*time.Second, client)
-	if err := attrs.AcquireLabel("media", appConfig.ExistAttributeName, existio_client.ValueTypeInteger, false); err != nil {
-		return nil, fmt.Errorf("failed to acquire label: %v", err)
+	apiClient := existio_client.NewClient(auth, client, func(auth *existio_client.OAuth2) {
+		log.Println("Exist rejected the access token, refreshed the tokens")
+	})
+	attrs := existio_client.NewAttrs(accessToken, 5*time.Second, apiClient)
+	attrs.Location = a.Config.Location
+	attrs.OnUpdate = a.auditSubmission
+	types, err := a.AttributeTypes()
+	if err != nil {
+		return nil, err
+	}
+	for _, mapping := range a.Config.Attributes {
+		if err := attrs.AcquireLabel(mapping.Group, mapping.Name, types[mapping.Name], false); err != nil {
+			return nil, fmt.Errorf("failed to acquire label %q: %v", mapping.Name, err)
+		}
 	}
 
-	state.SaveStates(storageInstance, sessions, nil, nil)
+	state.SaveStates(a.Storage, sessions, nil, nil)
 	return attrs, nil
 }
 
-// Main function
-func main() {
-	// Parse command line arguments
-	daysFlag := flag.Int("days", 3, "Number of days to consider for changing stats")
-	verboseFlag := flag.Bool("verbose", false, "Enable verbose logging")
-	todayValueFlag := flag.Int("today", -1, "Value to set for today's stats [-1 to skip]")
-	yesterdayValueFlag := flag.Int("yesterday", -1, "Value to set for yesterdays's stats [-1 to skip]")
-	flag.Parse()
+// LockState locks the state directory for the rest of the run, so that a
+// run started while another one hangs doesn't count articles twice, and
+// upgrades the state written by older versions. The lock is released when
+// the process exits. Dry runs don't write state and are handled like
+// read-only commands, see PrepareState.
+func (a *App) LockState() {
+	if a.DryRun {
+		a.PrepareState()
+		return
+	}
+	a.lockState()
+	if err := a.Storage.Migrate(); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	// Set up logging
-	if *verboseFlag {
-		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	} else {
-		log.SetFlags(log.Ldate | log.Ltime)
+// PrepareState upgrades the state written by older versions before a
+// read-only command reads it. The state is only locked when there is
+// something to upgrade, so a stuck run doesn't block these commands.
+func (a *App) PrepareState() {
+	if !a.Storage.MigrationPending() {
+		return
+	}
+	a.lockState()
+	if err := a.Storage.Migrate(); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	days := *daysFlag
-	if days <= 0 {
-		log.Fatal("Days must be a positive integer")
+func (a *App) lockState() {
+	if err := a.Storage.Lock(a.Config.LockTimeout); err != nil {
+		log.Fatalf("%v. If the other run is stuck, stop it or wait longer with -lock-timeout or LOCK_TIMEOUT", err)
 	}
+}
 
-	// Load states
-	sessions, articles, readingStats := state.LoadStates(storageInstance)
+// Audit queues entries describing state changes. They are written to the
+// audit log by FlushAudit once the state is saved, so a failed run leaves no
+// trace of changes that were never stored. Nothing is recorded in dry-run mode.
+func (a *App) Audit(entries ...state.AuditEntry) {
+	if a.DryRun {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Time.IsZero() {
+			entry.Time = time.Now()
+		}
+		if entry.Command == "" {
+			entry.Command = a.Command
+		}
+		a.pendingAudit = append(a.pendingAudit, entry)
+	}
+}
 
-	// Initialize HTTP client
-	client := existio_client.StartSession()
+// AuditArticle records that an article was counted, along with the new count of its day
+func (a *App) AuditArticle(article state.Article, count int) {
+	a.Audit(state.AuditEntry{
+		Event:    state.AuditArticle,
+		Date:     article.Day,
+		Article:  article.GUID,
+		Title:    article.Title,
+		OldValue: count - 1,
+		NewValue: count,
+	})
+}
 
-	// Get Exist.io session
-	_, err := GetExistSession(&sessions, client)
-	if err != nil {
-		log.Fatalf("Failed to get Exist session: %v", err)
+// FlushAudit writes the queued entries to the audit log
+func (a *App) FlushAudit() {
+	if len(a.pendingAudit) == 0 {
+		return
 	}
-
-	// Get Exist.io attributes client
-	attrs, err := GetExistAttrs(&sessions, client)
-	if err != nil {
-		log.Fatalf("Failed to get Exist attributes: %v", err)
+	if err := a.Storage.AppendAudit(a.pendingAudit...); err != nil {
+		log.Printf("Warning: failed to write the audit log: %v", err)
 	}
+	a.pendingAudit = nil
+}
 
-	// Fetch Instapaper RSS feed
-	resp, err := client.Get(appConfig.InstapaperArchiveRSS)
+// auditSubmission records a request to Exist.io right away, whether it succeeded or not
+func (a *App) auditSubmission(data []map[string]interface{}, status int, err error) {
+	entry := state.AuditEntry{
+		Event:   state.AuditSubmission,
+		Command: a.Command,
+		Payload: data,
+		Status:  status,
+	}
 	if err != nil {
-		log.Fatalf("Failed to fetch Instapaper feed: %v", err)
+		entry.Message = err.Error()
+	}
+	if err := a.Storage.AppendAudit(entry); err != nil {
+		log.Printf("Warning: failed to write the audit log: %v", err)
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("Instapaper: Failed to fetch feed! Status code: %d", resp.StatusCode)
+// ConnectExist authenticates with Exist.io and acquires the attributes.
+// In dry-run mode it returns a client that is only used for formatting.
+func (a *App) ConnectExist(sessions *state.Sessions, client *http.Client) (*existio_client.Attrs, error) {
+	if a.DryRun {
+		attrs := existio_client.NewAttrs("", 5*time.Second, client)
+		attrs.Location = a.Config.Location
+		return attrs, nil
 	}
 
-	// Parse RSS feed
-	body, err := io.ReadAll(resp.Body)
+	auth, err := a.GetExistSession(sessions, client)
 	if err != nil {
-		log.Fatalf("Failed to read response body: %v", err)
+		return nil, fmt.Errorf("failed to get Exist session: %v", err)
 	}
 
-	var rss RSS
-	if err := xml.Unmarshal(body, &rss); err != nil {
-		log.Fatalf("Failed to parse RSS feed: %v", err)
+	attrs, err := a.GetExistAttrs(sessions, auth, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Exist attributes: %v", err)
 	}
+	return attrs, nil
+}
 
-	// Process articles
-	today := time.Now().Format("2006-01-02")
-	for _, item := range rss.Channel.Items {
-		url := item.GUID
-		if !articles[url] {
-			articles[url] = true
-			readingStats[today]++
+// FetchArchive fetches the archived articles from the Instapaper Full API
+// when a client is given, and from the archive RSS feed otherwise. Through the
+// API, it fetches the 500 most recent articles, or the whole archive if whole
+// is set.
+func (a *App) FetchArchive(instapaper *instapaper_client.Client, client *http.Client, now time.Time, whole bool) ([]state.Article, error) {
+	if instapaper != nil {
+		var bookmarks []instapaper_client.Bookmark
+		var err error
+		if whole {
+			bookmarks, err = instapaper.ListAllBookmarks(instapaper_client.FolderArchive)
+		} else {
+			bookmarks, err = instapaper.ListBookmarks(instapaper_client.FolderArchive, instapaper_client.BookmarksListLimit)
+		}
+		if err != nil {
+			return nil, err
 		}
+		return BookmarkArticles(bookmarks, now), nil
 	}
-	if *todayValueFlag >= 0 {
-		readingStats[today] = *todayValueFlag
+
+	rss, err := FetchFeed(client, a.Config.InstapaperArchiveRSS)
+	if err != nil {
+		return nil, err
 	}
+	return FeedArticles(rss, now), nil
+}
 
-	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-	if *yesterdayValueFlag >= 0 {
-		readingStats[yesterday] = *yesterdayValueFlag
+// Main function
+func main() {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		config.PrintMissingVarsHelp()
+		os.Exit(1)
 	}
 
-	// Prepare data for submission
-	var data []map[string]interface{}
-	currentTime := time.Now()
-	for i := 0; i < days; i++ {
-		date := currentTime.AddDate(0, 0, -i)
-		dateStr := date.Format("2006-01-02")
-		count := readingStats[dateStr]
-		log.Printf("%s = %d", dateStr, count)
-		data = append(data, attrs.FormatSubmission(date, appConfig.ExistAttributeName, count))
+	// Initialize storage
+	stateDir, err := storage.DefaultDir("instapaper-to-exist")
+	if err != nil {
+		log.Fatalf("Failed to get user home directory: %v", err)
+	}
+	files, err := storage.NewStorage(stateDir)
+	if err != nil {
+		log.Fatalf("Failed to create state directory: %v", err)
 	}
+	store, err := OpenStorage(cfg, files)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	secrets, err := OpenSecrets(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	app := NewApp(cfg, state.NewSecretStorage(store, secrets), nil)
 
-	// Submit data to Exist.io
-	if err := attrs.UpdateBatch(data); err != nil {
-		log.Fatalf("Failed to update batch: %v", err)
+	args := os.Args[1:]
+	// Without a command, run a sync so existing cron entries keep working
+	name := "sync"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	// Save states
-	state.SaveStates(storageInstance, &sessions, &articles, &readingStats)
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+	app.Command = cmd.Name
+	cmd.Run(app, args)
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// Override sets or adjusts the article count of a range of dates
+type Override struct {
+	First    time.Time
+	Last     time.Time
+	Value    int
+	Relative bool // add Value to the current count instead of replacing it
+	Spec     string
+}
+
+// ParseOverride parses DATE[..DATE]=[+|-]N, where DATE is YYYY-MM-DD,
+// "today" or "yesterday". A signed value adjusts the current count.
+func (a *App) ParseOverride(spec string) (Override, error) {
+	dates, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return Override{}, fmt.Errorf("invalid override %q, expected DATE[..DATE]=[+|-]N", spec)
+	}
+
+	override := Override{Spec: spec}
+	first, last, isRange := strings.Cut(strings.TrimSpace(dates), "..")
+	var err error
+	if override.First, err = a.parseDay(first); err != nil {
+		return Override{}, err
+	}
+	override.Last = override.First
+	if isRange {
+		if override.Last, err = a.parseDay(last); err != nil {
+			return Override{}, err
+		}
+	}
+	if override.Last.Before(override.First) {
+		return Override{}, fmt.Errorf("invalid override %q: range ends before it starts", spec)
+	}
+
+	value = strings.TrimSpace(value)
+	override.Relative = strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+	if override.Value, err = strconv.Atoi(value); err != nil {
+		return Override{}, fmt.Errorf("invalid override %q: %v", spec, err)
+	}
+	if !override.Relative && override.Value < 0 {
+		return Override{}, fmt.Errorf("invalid override %q: value must not be negative", spec)
+	}
+	return override, nil
+}
+
+// parseDay parses YYYY-MM-DD, "today" or "yesterday" as a day in the configured time zone
+func (a *App) parseDay(value string) (time.Time, error) {
+	today := a.Config.DayOf(a.Config.Now())
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+	date, err := a.Config.ParseDate(strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+	}
+	return date, nil
+}
+
+// CheckOverridable fails when no attribute counts archived articles, e.g. in
+// the progress count modes, since overrides only change those counts
+func (a *App) CheckOverridable() error {
+	if a.Config.CountsArticles() {
+		return nil
+	}
+	return fmt.Errorf("overrides change the number of archived articles, which no configured attribute submits (COUNT_MODE=%s)", a.Config.CountMode)
+}
+
+// Dates returns every date the override covers
+func (o Override) Dates() []time.Time {
+	return DateRange(o.First, o.Last)
+}
+
+// ApplyOverride changes the counts in readingStats, records every change in
+// the audit log and returns the dates the override covers
+func (a *App) ApplyOverride(o Override, readingStats state.ReadingStats) []time.Time {
+	dates := o.Dates()
+	for _, date := range dates {
+		dateStr := date.Format("2006-01-02")
+		old := readingStats[dateStr]
+		value := o.Value
+		if o.Relative {
+			value = max(old+o.Value, 0)
+		}
+		readingStats[dateStr] = value
+		log.Printf("%s: %d -> %d (%s)", dateStr, old, value, o.Spec)
+
+		a.Audit(state.AuditEntry{
+			Event:    state.AuditOverride,
+			Date:     dateStr,
+			OldValue: old,
+			NewValue: value,
+			Message:  o.Spec,
+		})
+	}
+	return dates
+}
+
+// overrideFlag collects repeated -set flags. They are parsed once the
+// time zone flags are applied, see ParseOverrides.
+type overrideFlag []string
+
+func (f *overrideFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *overrideFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// ParseOverrides parses the collected specs
+func (a *App) ParseOverrides(specs overrideFlag) ([]Override, error) {
+	var overrides []Override
+	for _, spec := range specs {
+		override, err := a.ParseOverride(spec)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// PrintPlan prints the planned submission as a per-day table followed by
+// the JSON payload that would be sent to Exist.io
+func (a *App) PrintPlan(w io.Writer, rows [][]string, data []map[string]interface{}) error {
+	if err := a.PrintTable(w, rows); err != nil {
+		return err
+	}
+
+	payload, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, string(payload))
+	return nil
+}
+
+// PrintTable prints per-day values under a header of the attribute names
+func (a *App) PrintTable(w io.Writer, rows [][]string) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := "Date\t"
+	for _, mapping := range a.Config.Attributes {
+		header += mapping.Name + "\t"
+	}
+	fmt.Fprintln(table, header)
+	for _, row := range rows {
+		for _, cell := range row {
+			fmt.Fprint(table, cell+"\t")
+		}
+		fmt.Fprintln(table)
+	}
+	return table.Flush()
+}
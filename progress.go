@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// FetchProgressBookmarks lists the bookmarks whose reading progress is tracked
+func FetchProgressBookmarks(instapaper *instapaper_client.Client) ([]instapaper_client.Bookmark, error) {
+	if instapaper == nil {
+		return nil, fmt.Errorf("counting reading progress requires the Instapaper API")
+	}
+
+	var bookmarks []instapaper_client.Bookmark
+	for _, folderID := range []string{instapaper_client.FolderUnread, instapaper_client.FolderArchive} {
+		folder, err := instapaper.ListBookmarks(folderID, instapaper_client.BookmarksListLimit)
+		if err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, folder...)
+	}
+	return bookmarks, nil
+}
+
+// ProgressTracked reports whether the reading progress of the bookmarks was
+// recorded before. Bookmarks that only carry their folder were never tracked.
+func ProgressTracked(progress *state.Progress) bool {
+	for _, bookmark := range progress.Bookmarks {
+		if !bookmark.UpdatedAt.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// SeedProgress records the current progress of the bookmarks as the baseline
+// later changes are counted from, without crediting anything
+func SeedProgress(progress *state.Progress, bookmarks []instapaper_client.Bookmark, now time.Time) {
+	for _, bookmark := range bookmarks {
+		tracked := progress.Bookmarks[bookmark.BookmarkID]
+		tracked.Progress = bookmark.Progress
+		tracked.UpdatedAt = bookmark.ProgressAt()
+		if tracked.UpdatedAt.IsZero() || tracked.UpdatedAt.After(now) {
+			tracked.UpdatedAt = now
+		}
+		progress.Bookmarks[bookmark.BookmarkID] = tracked
+	}
+}
+
+// TrackProgress records progress changes of the bookmarks and credits them to
+// the day of their progress timestamp. It returns the days that changed.
+func (a *App) TrackProgress(progress *state.Progress, bookmarks []instapaper_client.Bookmark, now time.Time) map[string]bool {
+	touchedDays := make(map[string]bool)
+	for _, bookmark := range bookmarks {
+		previous := progress.Bookmarks[bookmark.BookmarkID]
+		current := state.BookmarkProgress{
+			Progress:  bookmark.Progress,
+			UpdatedAt: bookmark.ProgressAt(),
+			Folder:    previous.Folder,
+		}
+		if current.UpdatedAt.IsZero() || current.UpdatedAt.After(now) {
+			current.UpdatedAt = now
+		}
+		progress.Bookmarks[bookmark.BookmarkID] = current
+
+		// Going back (e.g. re-reading from the start) is not reading less
+		delta := current.Progress - previous.Progress
+		if delta <= 0 {
+			continue
+		}
+
+		day := a.Config.DateOf(current.UpdatedAt)
+		a.Audit(state.AuditEntry{
+			Event:    state.AuditProgress,
+			Date:     day,
+			Article:  fmt.Sprint(bookmark.BookmarkID),
+			Title:    bookmark.Title,
+			OldValue: previous.Progress,
+			NewValue: current.Progress,
+		})
+		progress.Gained[day] += delta
+		if previous.Progress < a.Config.ProgressThreshold && current.Progress >= a.Config.ProgressThreshold {
+			progress.Completed[day]++
+		}
+		touchedDays[day] = true
+	}
+	return touchedDays
+}
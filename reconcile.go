@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/config"
+	"github.com/ihoru/instapaper-to-exist/existio_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// Reconcile compares the planned submissions with the values already stored
+// in Exist.io and drops the ones that wouldn't change anything. With noLower,
+// values raised in Exist.io (e.g. manual corrections) are kept, and adopted
+// into readingStats for count attributes so later articles add on top of them.
+func (a *App) Reconcile(attrs *existio_client.Attrs, data []map[string]interface{}, dates []time.Time, readingStats state.ReadingStats, noLower bool) []map[string]interface{} {
+	if len(data) == 0 || len(dates) == 0 {
+		return data
+	}
+
+	var labels []string
+	countAttrs := make(map[string]bool)
+	for _, mapping := range a.Config.Attributes {
+		labels = append(labels, mapping.Name)
+		if mapping.Aggregate == config.AggregateCount {
+			countAttrs[attrs.LabelToAttr(mapping.Name)] = true
+		}
+	}
+
+	dateMin, dateMax := dates[0], dates[0]
+	for _, date := range dates {
+		if date.Before(dateMin) {
+			dateMin = date
+		}
+		if date.After(dateMax) {
+			dateMax = date
+		}
+	}
+
+	remote, err := attrs.GetValues(labels, dateMin, dateMax)
+	if errors.Is(err, existio_client.ErrForbidden) && noLower {
+		// Submitting everything would silently undo the manual corrections
+		log.Fatalf("%v. The stored tokens lack the %s scope needed by -no-lower, run `%s auth` again", err, a.ExistScope(), os.Args[0])
+	}
+	if err != nil {
+		log.Printf("Warning: failed to read current Exist values, submitting everything: %v", err)
+		return data
+	}
+
+	var changed []map[string]interface{}
+	for _, submission := range data {
+		name := submission["name"].(string)
+		date := submission["date"].(string)
+		value := submission["value"]
+
+		current, ok := remote.Value(name, date)
+		if !ok {
+			changed = append(changed, submission)
+			continue
+		}
+		if existio_client.SameValue(current, value) {
+			log.Printf("%s %s = %v is unchanged, skipping", date, name, value)
+			continue
+		}
+		if noLower && isLower(value, current) {
+			log.Printf("%s %s: keeping %v set in Exist instead of lowering it to %v", date, name, current, value)
+			if countAttrs[name] {
+				if count, ok := existio_client.Number(current); ok {
+					a.Audit(state.AuditEntry{
+						Event:    state.AuditAdopt,
+						Date:     date,
+						OldValue: readingStats[date],
+						NewValue: int(count),
+						Message:  "kept the value set in Exist",
+					})
+					readingStats[date] = int(count)
+				}
+			}
+			continue
+		}
+		changed = append(changed, submission)
+	}
+	return changed
+}
+
+// isLower reports whether a numeric value is lower than the current one
+func isLower(value, current interface{}) bool {
+	v, ok := existio_client.Number(value)
+	if !ok {
+		return false
+	}
+	c, ok := existio_client.Number(current)
+	return ok && v < c
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestIsLower(t *testing.T) {
+	tests := []struct {
+		value, current interface{}
+		want           bool
+	}{
+		{1, 2.0, true},
+		{2, 2.0, false},
+		{int64(1), json.Number("3"), true},
+		{1.5, 2, true},
+		{3, json.Number("2.5"), false},
+		{1, json.Number("n/a"), false},
+		{1, "2", false},
+	}
+	for _, test := range tests {
+		if got := isLower(test.value, test.current); got != test.want {
+			t.Errorf("isLower(%#v, %#v) = %v, want %v", test.value, test.current, got, test.want)
+		}
+	}
+}
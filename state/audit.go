@@ -0,0 +1,61 @@
+package state
+
+import (
+	"time"
+)
+
+// AuditFile is the append-only log of state changes, one JSON object per line
+const AuditFile = "audit.jsonl"
+
+// Audit events
+const (
+	AuditArticle    = "article"    // new article counted on a day
+	AuditProgress   = "progress"   // reading progress of a bookmark advanced
+	AuditOverride   = "override"   // manual change of a day's count
+	AuditAdopt      = "adopt"      // count raised in Exist adopted into the stats
+	AuditSubmission = "submission" // values sent to Exist
+	AuditReset      = "reset"      // state files removed
+)
+
+// AuditEntry records a single change
+type AuditEntry struct {
+	Time     time.Time   `json:"time"`
+	Event    string      `json:"event"`
+	Date     string      `json:"date,omitempty"`
+	Command  string      `json:"command,omitempty"`
+	Article  string      `json:"article,omitempty"`
+	Title    string      `json:"title,omitempty"`
+	OldValue interface{} `json:"old_value,omitempty"`
+	NewValue interface{} `json:"new_value,omitempty"`
+	Message  string      `json:"message,omitempty"`
+	// Exist submissions
+	Payload []map[string]interface{} `json:"payload,omitempty"`
+	Status  int                      `json:"status,omitempty"`
+}
+
+// Dates returns the days the entry is about, including the dates of a submission payload
+func (e AuditEntry) Dates() []string {
+	var dates []string
+	if e.Date != "" {
+		dates = append(dates, e.Date)
+	}
+	seen := make(map[string]bool)
+	for _, submission := range e.Payload {
+		date, ok := submission["date"].(string)
+		if ok && !seen[date] {
+			seen[date] = true
+			dates = append(dates, date)
+		}
+	}
+	return dates
+}
+
+// Covers reports whether the entry is about a day between first and last, inclusive
+func (e AuditEntry) Covers(first, last string) bool {
+	for _, date := range e.Dates() {
+		if date >= first && date <= last {
+			return true
+		}
+	}
+	return false
+}
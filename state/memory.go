@@ -0,0 +1,182 @@
+package state
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps the state in memory, e.g. to run the commands against
+// a prepared state without touching the state directory. Loads and saves
+// copy the state, so callers can't change the stored state by accident.
+type MemoryStorage struct {
+	mu           sync.Mutex
+	sessions     *Sessions
+	articles     Articles
+	readingStats ReadingStats
+	progress     *Progress
+	audit        []AuditEntry
+}
+
+// NewMemoryStorage creates an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// Location describes the storage
+func (m *MemoryStorage) Location() string {
+	return "memory"
+}
+
+// Lock does nothing, the state belongs to a single process
+func (m *MemoryStorage) Lock(timeout time.Duration) error {
+	return nil
+}
+
+// IsNew reports whether the articles were never saved
+func (m *MemoryStorage) IsNew() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.articles == nil
+}
+
+// MigrationPending reports false, there is no state of older versions
+func (m *MemoryStorage) MigrationPending() bool {
+	return false
+}
+
+// Migrate does nothing
+func (m *MemoryStorage) Migrate() error {
+	return nil
+}
+
+func (m *MemoryStorage) LoadSessions() (Sessions, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions == nil {
+		return Sessions{}, nil
+	}
+	return *m.sessions, nil
+}
+
+func (m *MemoryStorage) SaveSessions(sessions *Sessions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := *sessions
+	m.sessions = &saved
+	return nil
+}
+
+func (m *MemoryStorage) LoadArticles() (Articles, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	articles := make(Articles, len(m.articles))
+	for guid, article := range m.articles {
+		articles[guid] = article
+	}
+	return articles, nil
+}
+
+func (m *MemoryStorage) SaveArticles(articles Articles) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.articles = make(Articles, len(articles))
+	for guid, article := range articles {
+		m.articles[guid] = article
+	}
+	return nil
+}
+
+func (m *MemoryStorage) LoadStats() (ReadingStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	readingStats := make(ReadingStats, len(m.readingStats))
+	for date, count := range m.readingStats {
+		readingStats[date] = count
+	}
+	return readingStats, nil
+}
+
+func (m *MemoryStorage) SaveStats(readingStats ReadingStats) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readingStats = make(ReadingStats, len(readingStats))
+	for date, count := range readingStats {
+		m.readingStats[date] = count
+	}
+	return nil
+}
+
+func (m *MemoryStorage) LoadProgress() (Progress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.progress == nil {
+		return Progress{}, nil
+	}
+	return copyProgress(m.progress), nil
+}
+
+func (m *MemoryStorage) SaveProgress(progress *Progress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := copyProgress(progress)
+	m.progress = &saved
+	return nil
+}
+
+// copyProgress copies the maps of progress
+func copyProgress(progress *Progress) Progress {
+	copied := Progress{
+		Bookmarks: make(map[int64]BookmarkProgress, len(progress.Bookmarks)),
+		Completed: make(ProgressStats, len(progress.Completed)),
+		Gained:    make(ProgressStats, len(progress.Gained)),
+	}
+	for id, bookmark := range progress.Bookmarks {
+		copied.Bookmarks[id] = bookmark
+	}
+	for date, value := range progress.Completed {
+		copied.Completed[date] = value
+	}
+	for date, value := range progress.Gained {
+		copied.Gained[date] = value
+	}
+	return copied
+}
+
+// AppendAudit adds entries to the audit log
+func (m *MemoryStorage) AppendAudit(entries ...AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range entries {
+		if entry.Time.IsZero() {
+			entry.Time = time.Now()
+		}
+		m.audit = append(m.audit, entry)
+	}
+	return nil
+}
+
+// LoadAudit returns the audit log
+func (m *MemoryStorage) LoadAudit() ([]AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]AuditEntry(nil), m.audit...), nil
+}
+
+// Remove forgets the given parts of the state
+func (m *MemoryStorage) Remove(names ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		switch name {
+		case SessionsFile:
+			m.sessions = nil
+		case ArticlesFile:
+			m.articles = nil
+		case StatsFile:
+			m.readingStats = nil
+		case ProgressFile:
+			m.progress = nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+
+	store "github.com/ihoru/instapaper-to-exist/storage"
+)
+
+func init() {
+	store.RegisterSchema(ArticlesFile, store.Schema{
+		Version: 2,
+		Migrations: map[int]store.Migration{
+			1: migrateArticlesV1,
+		},
+	})
+}
+
+// migrateArticlesV1 turns the bare set of article URLs of version 1 into articles
+func migrateArticlesV1(data json.RawMessage) (json.RawMessage, error) {
+	var urls map[string]bool
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return nil, err
+	}
+	articles := make(Articles, len(urls))
+	for url := range urls {
+		articles[url] = Article{GUID: url}
+	}
+	return json.Marshal(articles)
+}
+
+// gobFile is a gob state file written by older versions
+type gobFile struct {
+	gobName  string
+	fileName string
+	version  int
+	data     func() interface{}
+}
+
+// gobFiles lists the gob state files of older versions and the schema
+// version of the JSON state files they are converted to
+var gobFiles = []gobFile{
+	{"sessions", SessionsFile, 1, func() interface{} { return &Sessions{} }},
+	{"articles", ArticlesFile, 1, func() interface{} { return &map[string]bool{} }}, // bare set of article URLs
+	{"stats", StatsFile, 1, func() interface{} { return &ReadingStats{} }},
+}
+
+// gobPending reports whether a gob state file still has to be converted
+func gobPending(storage *store.Storage) bool {
+	for _, legacy := range gobFiles {
+		if storage.Exists(legacy.gobName) && !storage.Exists(legacy.fileName) {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateGob converts the gob state files of older versions to JSON, once
+func migrateGob(storage *store.Storage) {
+	for _, legacy := range gobFiles {
+		if err := storage.MigrateGob(legacy.gobName, legacy.fileName, legacy.version, legacy.data()); err != nil {
+			log.Printf("Warning: failed to migrate %s: %v", legacy.gobName, err)
+		}
+	}
+}
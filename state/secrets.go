@@ -0,0 +1,115 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	store "github.com/ihoru/instapaper-to-exist/storage"
+)
+
+// ErrSecrets marks stored tokens that can't be decrypted
+var ErrSecrets = errors.New("failed to decrypt the stored tokens")
+
+// backupRemover is implemented by storages that keep earlier generations of the state
+type backupRemover interface {
+	RemoveBackups(fileName string) error
+}
+
+// SecretStorage encrypts the OAuth tokens in the sessions before they are
+// stored and decrypts them when they are loaded. The rest of the state is
+// passed through unchanged.
+type SecretStorage struct {
+	Storage
+	cipher *store.Cipher
+	locked bool
+}
+
+// NewSecretStorage wraps storage. Without a cipher, the tokens are stored in
+// plaintext and loading tokens that were encrypted fails with ErrSecrets.
+func NewSecretStorage(storage Storage, cipher *store.Cipher) *SecretStorage {
+	return &SecretStorage{Storage: storage, cipher: cipher}
+}
+
+// secrets returns the fields of the sessions that hold tokens
+func (s *Sessions) secrets() []*string {
+	return []*string{
+		&s.Exist.AccessToken,
+		&s.Exist.RefreshToken,
+		&s.Instapaper.Token,
+		&s.Instapaper.TokenSecret,
+	}
+}
+
+// Lock locks the underlying storage
+func (s *SecretStorage) Lock(timeout time.Duration) error {
+	if err := s.Storage.Lock(timeout); err != nil {
+		return err
+	}
+	s.locked = true
+	return nil
+}
+
+// LoadSessions loads and decrypts the sessions. Once the storage is locked,
+// tokens stored in plaintext by older versions are encrypted right away, and
+// the earlier generations of the sessions that still hold them are removed.
+// Without the lock, e.g. in dry runs and read-only commands, nothing is written.
+func (s *SecretStorage) LoadSessions() (Sessions, error) {
+	sessions, err := s.Storage.LoadSessions()
+	if err != nil {
+		return sessions, err
+	}
+
+	plaintext := false
+	for _, field := range sessions.secrets() {
+		if *field == "" {
+			continue
+		}
+		if !store.IsEncrypted(*field) {
+			plaintext = true
+			continue
+		}
+		if s.cipher == nil {
+			return Sessions{}, fmt.Errorf("%w: they are encrypted, set SECRETS_PASSPHRASE or SECRETS_KEYFILE", ErrSecrets)
+		}
+		value, err := s.cipher.Decrypt(*field)
+		if err != nil {
+			return Sessions{}, fmt.Errorf("%w: %v", ErrSecrets, err)
+		}
+		*field = value
+	}
+
+	if plaintext && s.cipher != nil && s.locked {
+		log.Printf("Encrypting the tokens stored in %s", s.Location())
+		if err := s.SaveSessions(&sessions); err != nil {
+			return sessions, fmt.Errorf("failed to encrypt the stored tokens: %v", err)
+		}
+		if remover, ok := s.Storage.(backupRemover); ok {
+			if err := remover.RemoveBackups(SessionsFile); err != nil {
+				log.Printf("Warning: failed to remove the plaintext copies of the tokens: %v", err)
+			}
+		}
+	}
+	return sessions, nil
+}
+
+// SaveSessions encrypts the tokens and saves the sessions
+func (s *SecretStorage) SaveSessions(sessions *Sessions) error {
+	if s.cipher == nil {
+		return s.Storage.SaveSessions(sessions)
+	}
+
+	encrypted := *sessions
+	for _, field := range encrypted.secrets() {
+		if *field == "" {
+			continue
+		}
+		value, err := s.cipher.Encrypt(*field)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt the tokens: %v", err)
+		}
+		*field = value
+	}
+	return s.Storage.SaveSessions(&encrypted)
+}
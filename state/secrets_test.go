@@ -0,0 +1,250 @@
+package state
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/existio_client"
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	store "github.com/ihoru/instapaper-to-exist/storage"
+)
+
+// testSessions returns sessions holding a token of every kind
+func testSessions() Sessions {
+	return Sessions{
+		Exist:      existio_client.ExistAuth{AccessToken: "exist-access", RefreshToken: "exist-refresh"},
+		Instapaper: instapaper_client.InstapaperAuth{Token: "instapaper-token", TokenSecret: "instapaper-secret"},
+	}
+}
+
+// backends opens the storages of the state directory of files
+var backends = []struct {
+	name string
+	open func(t *testing.T, files *store.Storage) Storage
+}{
+	{"file", func(t *testing.T, files *store.Storage) Storage {
+		return NewFileStorage(files)
+	}},
+	{"sqlite", func(t *testing.T, files *store.Storage) Storage {
+		return openTestSQLite(t, files)
+	}},
+}
+
+func TestSecretStorageRemovesPlaintextSessions(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files, err := store.NewStorage(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { files.Unlock() })
+
+			// The gob sessions of older versions, migrated to sessions.json
+			// and kept as sessions.gob, plus a backup and a corrupted generation
+			var legacy bytes.Buffer
+			sessions := testSessions()
+			if err := gob.NewEncoder(&legacy).Encode(&sessions); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "sessions"), legacy.Bytes(), 0600); err != nil {
+				t.Fatal(err)
+			}
+			legacy.Reset()
+			if err := gob.NewEncoder(&legacy).Encode(map[string]bool{"http://example.com/": true}); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "articles"), legacy.Bytes(), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cipher, err := store.NewCipher([]byte("passphrase"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			secrets := NewSecretStorage(backend.open(t, files), cipher)
+			if err := secrets.Lock(time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if err := secrets.Migrate(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "sessions.gob")); err != nil {
+				t.Fatal(err)
+			}
+			plaintext, err := os.ReadFile(filepath.Join(dir, SessionsFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{SessionsFile + ".bak", SessionsFile + ".corrupt"} {
+				if err := os.WriteFile(filepath.Join(dir, name), plaintext, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			loaded, err := secrets.LoadSessions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded != testSessions() {
+				t.Errorf("loaded %+v", loaded)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens := testSessions()
+			for _, entry := range entries {
+				content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, token := range tokens.secrets() {
+					if bytes.Contains(content, []byte(*token)) {
+						t.Errorf("%s still holds the token %q", entry.Name(), *token)
+					}
+				}
+			}
+
+			reloaded, err := NewSecretStorage(backend.open(t, files), cipher).LoadSessions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reloaded != testSessions() {
+				t.Errorf("reloaded %+v", reloaded)
+			}
+		})
+	}
+}
+
+func TestCipher(t *testing.T) {
+	encrypter, err := store.NewCipher([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := encrypter.Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.IsEncrypted(encrypted) || strings.Contains(encrypted, "token") {
+		t.Fatalf("encrypted to %q", encrypted)
+	}
+	again, err := encrypter.Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Error("the same value was encrypted twice with the same nonce")
+	}
+
+	tampered := []byte(encrypted)
+	if tampered[len(tampered)-5] == 'A' {
+		tampered[len(tampered)-5] = 'B'
+	} else {
+		tampered[len(tampered)-5] = 'A'
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		value  string
+		err    bool
+	}{
+		{"same passphrase", "passphrase", encrypted, false},
+		{"keyfile with a trailing newline", "passphrase\n", encrypted, false},
+		{"wrong passphrase", "other passphrase", encrypted, true},
+		{"tampered value", "passphrase", string(tampered), true},
+		{"truncated value", "passphrase", encrypted[:12], true},
+		{"plaintext value", "passphrase", "token", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decrypter, err := store.NewCipher([]byte(test.secret))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decrypter.Decrypt(test.value)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if !test.err && got != "token" {
+				t.Errorf("decrypted to %q", got)
+			}
+		})
+	}
+
+	if _, err := store.NewCipher([]byte(" \n")); err == nil {
+		t.Error("created a cipher from an empty passphrase")
+	}
+}
+
+func TestSecretStorage(t *testing.T) {
+	tests := []struct {
+		name      string
+		stored    string // passphrase the stored tokens were encrypted with, if any
+		secret    string // passphrase of the storage, if any
+		locked    bool
+		err       error
+		encrypted bool // the stored tokens are encrypted after loading
+	}{
+		{name: "plaintext without passphrase", locked: true},
+		{name: "plaintext in a read-only run", secret: "passphrase"},
+		{name: "plaintext is encrypted", secret: "passphrase", locked: true, encrypted: true},
+		{name: "encrypted", stored: "passphrase", secret: "passphrase", encrypted: true},
+		{name: "encrypted without passphrase", stored: "passphrase", err: ErrSecrets, encrypted: true},
+		{name: "wrong passphrase", stored: "passphrase", secret: "other passphrase", locked: true, err: ErrSecrets, encrypted: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := store.NewStorage(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { files.Unlock() })
+			storage := NewFileStorage(files)
+			newCipher := func(secret string) *store.Cipher {
+				if secret == "" {
+					return nil
+				}
+				cipher, err := store.NewCipher([]byte(secret))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return cipher
+			}
+
+			sessions := testSessions()
+			if err := NewSecretStorage(storage, newCipher(test.stored)).SaveSessions(&sessions); err != nil {
+				t.Fatal(err)
+			}
+
+			secrets := NewSecretStorage(storage, newCipher(test.secret))
+			if test.locked {
+				if err := secrets.Lock(time.Second); err != nil {
+					t.Fatal(err)
+				}
+			}
+			loaded, err := secrets.LoadSessions()
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err == nil && loaded != testSessions() {
+				t.Errorf("loaded %+v", loaded)
+			}
+
+			content, err := os.ReadFile(filepath.Join(files.Dir(), SessionsFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted := !bytes.Contains(content, []byte("exist-access")); encrypted != test.encrypted {
+				t.Errorf("the stored tokens are encrypted: %v, want %v", encrypted, test.encrypted)
+			}
+		})
+	}
+}
@@ -0,0 +1,685 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	store "github.com/ihoru/instapaper-to-exist/storage"
+)
+
+// DatabaseFile is the default name of the SQLite database in the state directory
+const DatabaseFile = "state.db"
+
+// sqliteMigrations create and upgrade the database schema. The schema
+// version is kept in PRAGMA user_version; migration i upgrades version i to i+1.
+var sqliteMigrations = []string{
+	`CREATE TABLE meta (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	CREATE TABLE sessions (
+		service TEXT PRIMARY KEY,
+		data    TEXT NOT NULL
+	);
+	CREATE TABLE articles (
+		guid        TEXT PRIMARY KEY,
+		title       TEXT NOT NULL DEFAULT '',
+		link        TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		pub_date    TEXT NOT NULL DEFAULT '',
+		seen_at     TEXT NOT NULL DEFAULT '',
+		day         TEXT NOT NULL DEFAULT '',
+		bookmark_id INTEGER NOT NULL DEFAULT 0,
+		folder      TEXT NOT NULL DEFAULT '',
+		words       INTEGER NOT NULL DEFAULT 0,
+		minutes     INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX articles_day ON articles (day);
+	CREATE TABLE daily_stats (
+		date     TEXT PRIMARY KEY,
+		articles INTEGER NOT NULL
+	);
+	CREATE TABLE bookmark_progress (
+		bookmark_id INTEGER PRIMARY KEY,
+		progress    REAL NOT NULL,
+		updated_at  TEXT NOT NULL,
+		folder      TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE progress_stats (
+		date      TEXT PRIMARY KEY,
+		completed REAL NOT NULL DEFAULT 0,
+		gained    REAL NOT NULL DEFAULT 0
+	);
+	CREATE TABLE audit_log (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		time      TEXT NOT NULL,
+		event     TEXT NOT NULL,
+		date      TEXT NOT NULL DEFAULT '',
+		command   TEXT NOT NULL DEFAULT '',
+		article   TEXT NOT NULL DEFAULT '',
+		title     TEXT NOT NULL DEFAULT '',
+		old_value TEXT,
+		new_value TEXT,
+		message   TEXT NOT NULL DEFAULT '',
+		payload   TEXT,
+		status    INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX audit_log_date ON audit_log (date);`,
+	// The import of the state files is recorded since version 2; databases
+	// that hold state were imported or used from scratch before
+	`INSERT OR IGNORE INTO meta (key, value) SELECT 'imported', value FROM meta WHERE key = 'initialized';`,
+}
+
+// SQLiteStorage keeps the state in an SQLite database. Only the articles and
+// stats that changed since they were loaded are written back.
+type SQLiteStorage struct {
+	db    *sql.DB
+	path  string
+	files *store.Storage // state directory, used for locking
+
+	articles     Articles
+	readingStats ReadingStats
+}
+
+// NewSQLiteStorage opens the database at path. The schema is created and
+// upgraded by Migrate.
+func NewSQLiteStorage(path string, files *store.Storage) (*SQLiteStorage, error) {
+	if sqliteDriver == "" {
+		return nil, fmt.Errorf("this build has no SQLite support (built with -tags nosqlite)")
+	}
+	db, err := sql.Open(sqliteDriver, "file:"+path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLiteStorage{db: db, path: path, files: files}
+
+	version, err := s.version()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version > len(sqliteMigrations) {
+		db.Close()
+		return nil, fmt.Errorf("%s: schema version %d was written by a newer release (supported up to %d)", path, version, len(sqliteMigrations))
+	}
+	return s, nil
+}
+
+// version returns the schema version of the database
+func (s *SQLiteStorage) version() (int, error) {
+	var version int
+	err := s.db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// imported reports whether the state files were imported into the database
+func (s *SQLiteStorage) imported() (bool, error) {
+	var imported bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM meta WHERE key = 'imported')").Scan(&imported)
+	return imported, err
+}
+
+// MigrationPending reports whether the schema is out of date, the state
+// files were not imported yet or permissions are to be restricted
+func (s *SQLiteStorage) MigrationPending() bool {
+	if !s.files.PermissionsRestricted() {
+		return true
+	}
+	if info, err := os.Stat(s.path); err != nil || info.Mode().Perm()&^0600 != 0 {
+		return true
+	}
+	if version, err := s.version(); err != nil || version < len(sqliteMigrations) {
+		return true
+	}
+	imported, err := s.imported()
+	return err != nil || !imported
+}
+
+// Migrate upgrades the schema and imports the state files found in the
+// directory of files, including the gob files of older versions, into a new
+// database. A failed import is retried on the next run.
+func (s *SQLiteStorage) Migrate() error {
+	if err := s.files.RestrictPermissions(); err != nil {
+		return fmt.Errorf("failed to restrict the permissions of %s: %v", s.files.Dir(), err)
+	}
+	if err := s.migrate(); err != nil {
+		return fmt.Errorf("failed to migrate %s: %v", s.path, err)
+	}
+	// The database holds the OAuth tokens
+	if err := os.Chmod(s.path, 0600); err != nil {
+		log.Printf("Warning: failed to restrict the permissions of %s: %v", s.path, err)
+	}
+	imported, err := s.imported()
+	if err != nil {
+		return err
+	}
+	if !imported {
+		if err := s.importFiles(NewFileStorage(s.files)); err != nil {
+			return fmt.Errorf("failed to import the state files into %s: %v", s.path, err)
+		}
+	}
+	return nil
+}
+
+// migrate upgrades the schema to the latest version
+func (s *SQLiteStorage) migrate() error {
+	version, err := s.version()
+	if err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("schema version %d was written by a newer release (supported up to %d)", version, len(sqliteMigrations))
+	}
+	for v := version; v < len(sqliteMigrations); v++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[v]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration from version %d: %v", v, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", v+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFiles copies the state kept in files into the database, if there is
+// any, and records the import. Everything is written in one transaction, so a
+// failed import leaves the database untouched.
+func (s *SQLiteStorage) importFiles(files *FileStorage) error {
+	// The gob files of older versions are imported through their JSON files
+	if err := files.Migrate(); err != nil {
+		return err
+	}
+	return s.transaction(func(tx *sql.Tx) error {
+		if !files.IsNew() {
+			sessions, articles, readingStats := LoadStates(files)
+			progress := LoadProgress(files)
+			audit, err := files.LoadAudit()
+			if err != nil {
+				return err
+			}
+
+			if err := saveSessions(tx, &sessions); err != nil {
+				return err
+			}
+			if err := s.saveArticles(tx, articles); err != nil {
+				return err
+			}
+			if err := s.saveStats(tx, readingStats); err != nil {
+				return err
+			}
+			if err := saveProgress(tx, &progress); err != nil {
+				return err
+			}
+			if err := appendAudit(tx, audit); err != nil {
+				return err
+			}
+			log.Printf("Importing %d articles and the state files of %s into %s", len(articles), files.Location(), s.path)
+		}
+		_, err := tx.Exec("INSERT INTO meta (key, value) VALUES ('imported', ?)", formatTime(time.Now()))
+		return err
+	})
+}
+
+// RemoveBackups deletes the state file the database was imported from, with
+// its earlier generations, e.g. after the sessions were rewritten without the
+// plaintext tokens that file still holds. The database is vacuumed, so the
+// replaced rows don't linger in its free pages either.
+func (s *SQLiteStorage) RemoveBackups(fileName string) error {
+	files := NewFileStorage(s.files)
+	if err := files.Remove(fileName); err != nil {
+		return err
+	}
+	if err := files.RemoveBackups(fileName); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("VACUUM")
+	return err
+}
+
+// Location returns the path of the database
+func (s *SQLiteStorage) Location() string {
+	return s.path
+}
+
+// Lock locks the state directory
+func (s *SQLiteStorage) Lock(timeout time.Duration) error {
+	return s.files.Lock(timeout)
+}
+
+// IsNew reports whether the articles were never saved
+func (s *SQLiteStorage) IsNew() bool {
+	var initialized bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM meta WHERE key = 'initialized')").Scan(&initialized)
+	if err != nil {
+		log.Printf("Failed to query %s: %v", s.path, err)
+		return true
+	}
+	return !initialized
+}
+
+func (s *SQLiteStorage) LoadSessions() (Sessions, error) {
+	var sessions Sessions
+	rows, err := s.db.Query("SELECT service, data FROM sessions")
+	if err != nil {
+		return sessions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var service, data string
+		if err := rows.Scan(&service, &data); err != nil {
+			return sessions, err
+		}
+		switch service {
+		case "exist":
+			err = json.Unmarshal([]byte(data), &sessions.Exist)
+		case "instapaper":
+			err = json.Unmarshal([]byte(data), &sessions.Instapaper)
+		}
+		if err != nil {
+			return sessions, fmt.Errorf("session %s: %v", service, err)
+		}
+	}
+	return sessions, rows.Err()
+}
+
+func (s *SQLiteStorage) SaveSessions(sessions *Sessions) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return saveSessions(tx, sessions)
+	})
+}
+
+func saveSessions(tx *sql.Tx, sessions *Sessions) error {
+	for service, auth := range map[string]interface{}{"exist": sessions.Exist, "instapaper": sessions.Instapaper} {
+		data, err := json.Marshal(auth)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO sessions (service, data) VALUES (?, ?)
+			ON CONFLICT (service) DO UPDATE SET data = excluded.data`, service, string(data))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) LoadArticles() (Articles, error) {
+	articles := make(Articles)
+	rows, err := s.db.Query(`SELECT guid, title, link, description, pub_date, seen_at, day,
+		bookmark_id, folder, words, minutes FROM articles`)
+	if err != nil {
+		return articles, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var article Article
+		var pubDate, seenAt string
+		err := rows.Scan(&article.GUID, &article.Title, &article.Link, &article.Description, &pubDate, &seenAt,
+			&article.Day, &article.BookmarkID, &article.Folder, &article.Words, &article.Minutes)
+		if err != nil {
+			return articles, err
+		}
+		article.PubDate = parseTime(pubDate)
+		article.SeenAt = parseTime(seenAt)
+		articles[article.GUID] = article
+	}
+	if err := rows.Err(); err != nil {
+		return articles, err
+	}
+
+	s.articles = make(Articles, len(articles))
+	for guid, article := range articles {
+		s.articles[guid] = article
+	}
+	return articles, nil
+}
+
+func (s *SQLiteStorage) SaveArticles(articles Articles) error {
+	err := s.transaction(func(tx *sql.Tx) error {
+		return s.saveArticles(tx, articles)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.articles = make(Articles, len(articles))
+	for guid, article := range articles {
+		s.articles[guid] = article
+	}
+	return nil
+}
+
+// saveArticles writes the articles that changed since they were loaded
+func (s *SQLiteStorage) saveArticles(tx *sql.Tx, articles Articles) error {
+	stmt, err := tx.Prepare(`INSERT INTO articles (guid, title, link, description, pub_date, seen_at, day,
+			bookmark_id, folder, words, minutes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (guid) DO UPDATE SET title = excluded.title, link = excluded.link,
+			description = excluded.description, pub_date = excluded.pub_date, seen_at = excluded.seen_at,
+			day = excluded.day, bookmark_id = excluded.bookmark_id, folder = excluded.folder,
+			words = excluded.words, minutes = excluded.minutes`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for guid, article := range articles {
+		if loaded, ok := s.articles[guid]; ok && loaded == article {
+			continue
+		}
+		_, err := stmt.Exec(guid, article.Title, article.Link, article.Description,
+			formatTime(article.PubDate), formatTime(article.SeenAt), article.Day,
+			article.BookmarkID, article.Folder, article.Words, article.Minutes)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("INSERT OR IGNORE INTO meta (key, value) VALUES ('initialized', ?)", formatTime(time.Now()))
+	return err
+}
+
+func (s *SQLiteStorage) LoadStats() (ReadingStats, error) {
+	readingStats := make(ReadingStats)
+	rows, err := s.db.Query("SELECT date, articles FROM daily_stats")
+	if err != nil {
+		return readingStats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var date string
+		var count int
+		if err := rows.Scan(&date, &count); err != nil {
+			return readingStats, err
+		}
+		readingStats[date] = count
+	}
+	if err := rows.Err(); err != nil {
+		return readingStats, err
+	}
+
+	s.readingStats = make(ReadingStats, len(readingStats))
+	for date, count := range readingStats {
+		s.readingStats[date] = count
+	}
+	return readingStats, nil
+}
+
+func (s *SQLiteStorage) SaveStats(readingStats ReadingStats) error {
+	err := s.transaction(func(tx *sql.Tx) error {
+		return s.saveStats(tx, readingStats)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.readingStats = make(ReadingStats, len(readingStats))
+	for date, count := range readingStats {
+		s.readingStats[date] = count
+	}
+	return nil
+}
+
+// saveStats writes the counts that changed since they were loaded
+func (s *SQLiteStorage) saveStats(tx *sql.Tx, readingStats ReadingStats) error {
+	for date, count := range readingStats {
+		if loaded, ok := s.readingStats[date]; ok && loaded == count {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO daily_stats (date, articles) VALUES (?, ?)
+			ON CONFLICT (date) DO UPDATE SET articles = excluded.articles`, date, count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) LoadProgress() (Progress, error) {
+	progress := Progress{
+		Bookmarks: make(map[int64]BookmarkProgress),
+		Completed: make(ProgressStats),
+		Gained:    make(ProgressStats),
+	}
+
+	rows, err := s.db.Query("SELECT bookmark_id, progress, updated_at, folder FROM bookmark_progress")
+	if err != nil {
+		return progress, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var bookmark BookmarkProgress
+		var updatedAt string
+		if err := rows.Scan(&id, &bookmark.Progress, &updatedAt, &bookmark.Folder); err != nil {
+			return progress, err
+		}
+		bookmark.UpdatedAt = parseTime(updatedAt)
+		progress.Bookmarks[id] = bookmark
+	}
+	if err := rows.Err(); err != nil {
+		return progress, err
+	}
+
+	rows, err = s.db.Query("SELECT date, completed, gained FROM progress_stats")
+	if err != nil {
+		return progress, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var date string
+		var completed, gained float64
+		if err := rows.Scan(&date, &completed, &gained); err != nil {
+			return progress, err
+		}
+		if completed != 0 {
+			progress.Completed[date] = completed
+		}
+		if gained != 0 {
+			progress.Gained[date] = gained
+		}
+	}
+	return progress, rows.Err()
+}
+
+func (s *SQLiteStorage) SaveProgress(progress *Progress) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return saveProgress(tx, progress)
+	})
+}
+
+func saveProgress(tx *sql.Tx, progress *Progress) error {
+	if _, err := tx.Exec("DELETE FROM bookmark_progress"); err != nil {
+		return err
+	}
+	for id, bookmark := range progress.Bookmarks {
+		_, err := tx.Exec("INSERT INTO bookmark_progress (bookmark_id, progress, updated_at, folder) VALUES (?, ?, ?, ?)",
+			id, bookmark.Progress, formatTime(bookmark.UpdatedAt), bookmark.Folder)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM progress_stats"); err != nil {
+		return err
+	}
+	dates := make(map[string]bool)
+	for date := range progress.Completed {
+		dates[date] = true
+	}
+	for date := range progress.Gained {
+		dates[date] = true
+	}
+	for date := range dates {
+		_, err := tx.Exec("INSERT INTO progress_stats (date, completed, gained) VALUES (?, ?, ?)",
+			date, progress.Completed[date], progress.Gained[date])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendAudit adds entries to the audit_log table
+func (s *SQLiteStorage) AppendAudit(entries ...AuditEntry) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return appendAudit(tx, entries)
+	})
+}
+
+func appendAudit(tx *sql.Tx, entries []AuditEntry) error {
+	for _, entry := range entries {
+		if entry.Time.IsZero() {
+			entry.Time = time.Now()
+		}
+		oldValue, err := jsonColumn(entry.OldValue)
+		if err != nil {
+			return err
+		}
+		newValue, err := jsonColumn(entry.NewValue)
+		if err != nil {
+			return err
+		}
+		var payload interface{}
+		if entry.Payload != nil {
+			if payload, err = jsonColumn(entry.Payload); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO audit_log (time, event, date, command, article, title,
+				old_value, new_value, message, payload, status)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			formatTime(entry.Time), entry.Event, entry.Date, entry.Command, entry.Article, entry.Title,
+			oldValue, newValue, entry.Message, payload, entry.Status)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadAudit reads the audit_log table
+func (s *SQLiteStorage) LoadAudit() ([]AuditEntry, error) {
+	rows, err := s.db.Query(`SELECT time, event, date, command, article, title, old_value, new_value,
+		message, payload, status FROM audit_log ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var entryTime string
+		var oldValue, newValue, payload sql.NullString
+		err := rows.Scan(&entryTime, &entry.Event, &entry.Date, &entry.Command, &entry.Article, &entry.Title,
+			&oldValue, &newValue, &entry.Message, &payload, &entry.Status)
+		if err != nil {
+			return nil, err
+		}
+		entry.Time = parseTime(entryTime)
+		for _, column := range []struct {
+			value  sql.NullString
+			target interface{}
+		}{{oldValue, &entry.OldValue}, {newValue, &entry.NewValue}, {payload, &entry.Payload}} {
+			if !column.value.Valid {
+				continue
+			}
+			if err := json.Unmarshal([]byte(column.value.String), column.target); err != nil {
+				return nil, fmt.Errorf("audit log: %v", err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Remove empties the tables holding the given parts of the state
+func (s *SQLiteStorage) Remove(names ...string) error {
+	tables := map[string][]string{
+		SessionsFile: {"sessions"},
+		ArticlesFile: {"articles"},
+		StatsFile:    {"daily_stats"},
+		ProgressFile: {"bookmark_progress", "progress_stats"},
+	}
+	return s.transaction(func(tx *sql.Tx) error {
+		for _, name := range names {
+			parts, ok := tables[name]
+			if !ok {
+				return fmt.Errorf("unknown part of the state %q", name)
+			}
+			for _, table := range parts {
+				if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+					return err
+				}
+			}
+			// Keep the record of the import, so the files aren't imported again
+			if name == ArticlesFile {
+				if _, err := tx.Exec("DELETE FROM meta WHERE key = 'initialized'"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the database
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// transaction runs fn in a transaction, committing it if fn succeeds
+func (s *SQLiteStorage) transaction(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// formatTime stores times as RFC 3339 text, the zero time as an empty string
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, value)
+	return t
+}
+
+// jsonColumn encodes a value for a nullable JSON column
+func jsonColumn(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+var (
+	_ Storage = (*FileStorage)(nil)
+	_ Storage = (*SQLiteStorage)(nil)
+)
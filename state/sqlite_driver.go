@@ -0,0 +1,10 @@
+//go:build !nosqlite
+
+package state
+
+import (
+	_ "modernc.org/sqlite" // pure-Go SQLite driver
+)
+
+// sqliteDriver is the database/sql driver of the SQLite backend
+const sqliteDriver = "sqlite"
@@ -0,0 +1,6 @@
+//go:build nosqlite
+
+package state
+
+// sqliteDriver is empty in builds without the SQLite backend
+const sqliteDriver = ""
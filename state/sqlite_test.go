@@ -0,0 +1,263 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	store "github.com/ihoru/instapaper-to-exist/storage"
+)
+
+// openTestSQLite opens the database in the state directory of files, or
+// skips the test in builds without SQLite support
+func openTestSQLite(t *testing.T, files *store.Storage) *SQLiteStorage {
+	t.Helper()
+	if sqliteDriver == "" {
+		t.Skip("built without SQLite support")
+	}
+	s, err := NewSQLiteStorage(filepath.Join(files.Dir(), DatabaseFile), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newTestSQLite returns a migrated database in a temporary directory
+func newTestSQLite(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	files, err := store.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := openTestSQLite(t, files)
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	s := newTestSQLite(t)
+	seenAt := time.Date(2026, 10, 1, 12, 30, 0, 123456789, time.UTC)
+	sessions := testSessions()
+	sessions.Exist.LastRefresh = seenAt
+	articles := Articles{
+		"a": {GUID: "a", Title: "A", Link: "https://example.com/a", Description: "About a",
+			PubDate: seenAt.Add(-time.Hour), SeenAt: seenAt, Day: "2026-10-01",
+			BookmarkID: 7, Folder: "Long reads", Words: 1200, Minutes: 5},
+		"b": {GUID: "b", SeenAt: seenAt},
+	}
+	readingStats := ReadingStats{"2026-10-01": 2, "2026-09-30": 0}
+	progress := Progress{
+		Bookmarks: map[int64]BookmarkProgress{7: {Progress: 0.5, UpdatedAt: seenAt, Folder: "Long reads"}},
+		Completed: ProgressStats{"2026-10-01": 1},
+		Gained:    ProgressStats{"2026-10-01": 1.5, "2026-09-30": 0.25},
+	}
+	audit := []AuditEntry{
+		{Time: seenAt, Event: AuditArticle, Date: "2026-10-01", Command: "sync", Article: "a", Title: "A",
+			OldValue: float64(1), NewValue: float64(2)},
+		{Time: seenAt, Event: AuditSubmission, Message: "submitted",
+			Payload: []map[string]interface{}{{"name": "articles", "date": "2026-10-01", "value": float64(2)}}, Status: 200},
+	}
+
+	if err := s.SaveSessions(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveArticles(articles); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveStats(readingStats); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveProgress(&progress); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendAudit(audit...); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestSQLite(t, s.files)
+	if reopened.IsNew() {
+		t.Error("the saved state is reported as new")
+	}
+	tests := []struct {
+		name string
+		load func() (interface{}, error)
+		want interface{}
+	}{
+		{"sessions", func() (interface{}, error) { return reopened.LoadSessions() }, sessions},
+		{"articles", func() (interface{}, error) { return reopened.LoadArticles() }, articles},
+		{"stats", func() (interface{}, error) { return reopened.LoadStats() }, readingStats},
+		{"progress", func() (interface{}, error) { return reopened.LoadProgress() }, progress},
+		{"audit", func() (interface{}, error) { return reopened.LoadAudit() }, audit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteSavesOnlyChangedRows(t *testing.T) {
+	tests := []struct {
+		name         string
+		change       func(articles Articles, readingStats ReadingStats)
+		wantTitles   map[string]string
+		wantArticles map[string]int
+	}{
+		{
+			name:         "unchanged",
+			change:       func(Articles, ReadingStats) {},
+			wantTitles:   map[string]string{"a": "changed elsewhere", "b": "B"},
+			wantArticles: map[string]int{"2026-10-01": 99, "2026-10-02": 1},
+		},
+		{
+			name: "changed",
+			change: func(articles Articles, readingStats ReadingStats) {
+				article := articles["a"]
+				article.Title = "A2"
+				articles["a"] = article
+				readingStats["2026-10-01"] = 3
+			},
+			wantTitles:   map[string]string{"a": "A2", "b": "B"},
+			wantArticles: map[string]int{"2026-10-01": 3, "2026-10-02": 1},
+		},
+		{
+			name: "added",
+			change: func(articles Articles, readingStats ReadingStats) {
+				articles["c"] = Article{GUID: "c", Title: "C"}
+				readingStats["2026-10-03"] = 1
+			},
+			wantTitles:   map[string]string{"a": "changed elsewhere", "b": "B", "c": "C"},
+			wantArticles: map[string]int{"2026-10-01": 99, "2026-10-02": 1, "2026-10-03": 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestSQLite(t)
+			if err := s.SaveArticles(Articles{"a": {GUID: "a", Title: "A"}, "b": {GUID: "b", Title: "B"}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SaveStats(ReadingStats{"2026-10-01": 2, "2026-10-02": 1}); err != nil {
+				t.Fatal(err)
+			}
+			articles, err := s.LoadArticles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			readingStats, err := s.LoadStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Rows that are written back lose these changes
+			if _, err := s.db.Exec("UPDATE articles SET title = 'changed elsewhere' WHERE guid = 'a'"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.db.Exec("UPDATE daily_stats SET articles = 99 WHERE date = '2026-10-01'"); err != nil {
+				t.Fatal(err)
+			}
+
+			test.change(articles, readingStats)
+			if err := s.SaveArticles(articles); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SaveStats(readingStats); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := s.db.Query("SELECT guid, title FROM articles")
+			if err != nil {
+				t.Fatal(err)
+			}
+			titles := make(map[string]string)
+			for rows.Next() {
+				var guid, title string
+				if err := rows.Scan(&guid, &title); err != nil {
+					t.Fatal(err)
+				}
+				titles[guid] = title
+			}
+			rows.Close()
+			if !reflect.DeepEqual(titles, test.wantTitles) {
+				t.Errorf("got titles %v, want %v", titles, test.wantTitles)
+			}
+
+			stored, err := s.LoadStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(map[string]int(stored), test.wantArticles) {
+				t.Errorf("got stats %v, want %v", stored, test.wantArticles)
+			}
+		})
+	}
+}
+
+func TestSQLiteImportsStateFiles(t *testing.T) {
+	files, err := store.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileStorage := NewFileStorage(files)
+	articles := Articles{"a": {GUID: "a", Title: "A", Day: "2026-10-01"}}
+	if err := fileStorage.SaveArticles(articles); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileStorage.SaveStats(ReadingStats{"2026-10-01": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openTestSQLite(t, files)
+	if !s.MigrationPending() {
+		t.Fatal("a new database has no migration pending")
+	}
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if s.MigrationPending() {
+		t.Error("a migration is still pending")
+	}
+	got, err := s.LoadArticles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, articles) {
+		t.Errorf("imported %v", got)
+	}
+
+	// Files changed later aren't imported again
+	if err := fileStorage.SaveStats(ReadingStats{"2026-10-01": 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	readingStats, err := s.LoadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readingStats["2026-10-01"] != 1 {
+		t.Errorf("imported the stats again: %v", readingStats)
+	}
+}
+
+func TestSQLiteRejectsNewerSchema(t *testing.T) {
+	s := newTestSQLite(t)
+	if _, err := s.db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewSQLiteStorage(s.path, s.files)
+	if err == nil || !strings.Contains(err.Error(), "newer release") {
+		t.Errorf("got error %v", err)
+	}
+}
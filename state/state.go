@@ -1,57 +1,146 @@
 package state
 
 import (
-	"encoding/gob"
+	"errors"
 	"github.com/ihoru/instapaper-to-exist/existio_client"
-	store "github.com/ihoru/instapaper-to-exist/storage"
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	"log"
 	"time"
 )
 
-func init() {
-	// Register types for gob encoding/decoding
-	gob.Register(time.Time{})
-}
+// Names of the state files
+const (
+	SessionsFile = "sessions.json"
+	ArticlesFile = "articles.json"
+	StatsFile    = "stats.json"
+	ProgressFile = "progress.json"
+)
 
 // Sessions storage
 type Sessions struct {
-	Exist existio_client.ExistAuth
+	Exist      existio_client.ExistAuth         `json:"exist"`
+	Instapaper instapaper_client.InstapaperAuth `json:"instapaper"`
 }
 
 // ReadingStats maps dates to article counts
 type ReadingStats map[string]int
 
-// Articles is a set of article URLs
-type Articles map[string]bool
+// Article holds the metadata of an archived Instapaper article
+type Article struct {
+	GUID        string    `json:"guid"`
+	Title       string    `json:"title,omitempty"`
+	Link        string    `json:"link,omitempty"`
+	Description string    `json:"description,omitempty"`
+	PubDate     time.Time `json:"pub_date"`
+	SeenAt      time.Time `json:"seen_at"`
+	Day         string    `json:"day,omitempty"`         // date the article was credited to in ReadingStats
+	BookmarkID  int64     `json:"bookmark_id,omitempty"` // Instapaper bookmark ID, when fetched through the API
+	Folder      string    `json:"folder,omitempty"`      // folder the bookmark was last seen in before being archived
+	Words       int       `json:"words,omitempty"`
+	Minutes     int       `json:"minutes,omitempty"` // estimated reading time
+}
 
-// LoadStates loads the state files (for backward compatibility)
-func LoadStates(storage *store.Storage) (Sessions, Articles, ReadingStats) {
-	sessions := Sessions{
-		Exist: existio_client.ExistAuth{},
+// ArchivedAt returns the moment the article was archived, falling back to
+// the time it was first seen when the feed carried no usable date
+func (a Article) ArchivedAt() time.Time {
+	if a.PubDate.IsZero() || (!a.SeenAt.IsZero() && a.PubDate.After(a.SeenAt)) {
+		return a.SeenAt
 	}
-	articles := make(Articles)
-	readingStats := make(ReadingStats)
+	return a.PubDate
+}
+
+// Articles maps article GUIDs to their metadata
+type Articles map[string]Article
+
+// BookmarkProgress is the last known reading progress of a bookmark
+type BookmarkProgress struct {
+	Progress  float64   `json:"progress"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Folder    string    `json:"folder,omitempty"` // title of the user folder the bookmark was last seen in
+}
+
+// ProgressStats maps dates to reading values derived from progress changes
+type ProgressStats map[string]float64
+
+// Progress tracks reading progress per bookmark and per day
+type Progress struct {
+	Bookmarks map[int64]BookmarkProgress `json:"bookmarks"`
+	Completed ProgressStats              `json:"completed"` // articles whose progress crossed the threshold
+	Gained    ProgressStats              `json:"gained"`    // progress gained, in article equivalents
+}
 
-	storage.Load("sessions", &sessions)
-	storage.Load("articles", &articles)
-	storage.Load("stats", &readingStats)
+// LoadStates loads the sessions, articles and stats (for backward compatibility)
+func LoadStates(storage Storage) (Sessions, Articles, ReadingStats) {
+	sessions, err := storage.LoadSessions()
+	if errors.Is(err, ErrSecrets) {
+		// Going on without the tokens would overwrite them on the next save
+		log.Fatal(err)
+	} else if err != nil {
+		log.Printf("Failed to load the sessions: %v", err)
+	}
+	articles, err := storage.LoadArticles()
+	if err != nil {
+		log.Printf("Failed to load the articles: %v", err)
+	}
+	if articles == nil {
+		articles = make(Articles)
+	}
+	readingStats, err := storage.LoadStats()
+	if err != nil {
+		log.Printf("Failed to load the stats: %v", err)
+	}
+	if readingStats == nil {
+		readingStats = make(ReadingStats)
+	}
 
 	return sessions, articles, readingStats
 }
 
-// SaveStates saves the state files (for backward compatibility)
-func SaveStates(storage *store.Storage, sessions *Sessions, articles *Articles, readingStats *ReadingStats) {
+// SaveStates saves the given parts of the state (for backward compatibility)
+func SaveStates(storage Storage, sessions *Sessions, articles *Articles, readingStats *ReadingStats) {
 	// Save sessions
 	if sessions != nil {
-		storage.Save("sessions", sessions)
+		if err := storage.SaveSessions(sessions); err != nil {
+			log.Printf("Failed to save the sessions: %v", err)
+		}
 	}
 
 	// Save articles
 	if articles != nil {
-		storage.Save("articles", articles)
+		if err := storage.SaveArticles(*articles); err != nil {
+			log.Printf("Failed to save the articles: %v", err)
+		}
 	}
 
 	// Save reading stats
 	if readingStats != nil {
-		storage.Save("stats", readingStats)
+		if err := storage.SaveStats(*readingStats); err != nil {
+			log.Printf("Failed to save the stats: %v", err)
+		}
+	}
+}
+
+// LoadProgress loads the reading progress state
+func LoadProgress(storage Storage) Progress {
+	progress, err := storage.LoadProgress()
+	if err != nil {
+		log.Printf("Failed to load the reading progress: %v", err)
+	}
+	if progress.Bookmarks == nil {
+		progress.Bookmarks = make(map[int64]BookmarkProgress)
+	}
+	if progress.Completed == nil {
+		progress.Completed = make(ProgressStats)
+	}
+	if progress.Gained == nil {
+		progress.Gained = make(ProgressStats)
+	}
+	return progress
+}
+
+// SaveProgress saves the reading progress state
+func SaveProgress(storage Storage, progress *Progress) {
+	if err := storage.SaveProgress(progress); err != nil {
+		log.Printf("Failed to save the reading progress: %v", err)
 	}
 }
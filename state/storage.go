@@ -0,0 +1,189 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	store "github.com/ihoru/instapaper-to-exist/storage"
+)
+
+// Storage persists the state of the program
+type Storage interface {
+	// Location describes where the state is kept
+	Location() string
+	// Lock takes an exclusive lock on the state for the rest of the run
+	Lock(timeout time.Duration) error
+	// IsNew reports whether no articles were ever recorded, i.e. the
+	// program was neither seeded nor backfilled yet
+	IsNew() bool
+	// MigrationPending reports whether the state written by older versions
+	// needs to be upgraded before it is read
+	MigrationPending() bool
+	// Migrate upgrades the state written by older versions and restricts
+	// its permissions. It must be called under the lock.
+	Migrate() error
+
+	LoadSessions() (Sessions, error)
+	SaveSessions(sessions *Sessions) error
+	LoadArticles() (Articles, error)
+	SaveArticles(articles Articles) error
+	LoadStats() (ReadingStats, error)
+	SaveStats(readingStats ReadingStats) error
+	LoadProgress() (Progress, error)
+	SaveProgress(progress *Progress) error
+
+	// AppendAudit adds entries to the audit log
+	AppendAudit(entries ...AuditEntry) error
+	// LoadAudit reads the whole audit log, oldest entry first
+	LoadAudit() ([]AuditEntry, error)
+
+	// Remove deletes the given parts of the state, named after their state
+	// files (SessionsFile, ArticlesFile, StatsFile and ProgressFile)
+	Remove(names ...string) error
+}
+
+// FileStorage keeps the state in versioned JSON files
+type FileStorage struct {
+	files *store.Storage
+}
+
+// NewFileStorage creates a FileStorage in the directory of files
+func NewFileStorage(files *store.Storage) *FileStorage {
+	return &FileStorage{files: files}
+}
+
+// Location returns the state directory
+func (f *FileStorage) Location() string {
+	return f.files.Dir()
+}
+
+// Lock locks the state directory
+func (f *FileStorage) Lock(timeout time.Duration) error {
+	return f.files.Lock(timeout)
+}
+
+// IsNew reports whether the articles file was never written
+func (f *FileStorage) IsNew() bool {
+	return !f.files.Exists(ArticlesFile)
+}
+
+// MigrationPending reports whether there are gob state files to convert or
+// permissions to restrict
+func (f *FileStorage) MigrationPending() bool {
+	return gobPending(f.files) || !f.files.PermissionsRestricted()
+}
+
+// Migrate restricts the permissions of the state directory and converts the
+// gob state files of older versions
+func (f *FileStorage) Migrate() error {
+	if err := f.files.RestrictPermissions(); err != nil {
+		return fmt.Errorf("failed to restrict the permissions of %s: %v", f.files.Dir(), err)
+	}
+	migrateGob(f.files)
+	return nil
+}
+
+func (f *FileStorage) LoadSessions() (Sessions, error) {
+	var sessions Sessions
+	err := f.files.Load(SessionsFile, &sessions)
+	return sessions, err
+}
+
+func (f *FileStorage) SaveSessions(sessions *Sessions) error {
+	return f.files.Save(SessionsFile, sessions)
+}
+
+func (f *FileStorage) LoadArticles() (Articles, error) {
+	articles := make(Articles)
+	err := f.files.Load(ArticlesFile, &articles)
+	return articles, err
+}
+
+func (f *FileStorage) SaveArticles(articles Articles) error {
+	return f.files.Save(ArticlesFile, articles)
+}
+
+func (f *FileStorage) LoadStats() (ReadingStats, error) {
+	readingStats := make(ReadingStats)
+	err := f.files.Load(StatsFile, &readingStats)
+	return readingStats, err
+}
+
+func (f *FileStorage) SaveStats(readingStats ReadingStats) error {
+	return f.files.Save(StatsFile, readingStats)
+}
+
+func (f *FileStorage) LoadProgress() (Progress, error) {
+	var progress Progress
+	err := f.files.Load(ProgressFile, &progress)
+	return progress, err
+}
+
+func (f *FileStorage) SaveProgress(progress *Progress) error {
+	return f.files.Save(ProgressFile, progress)
+}
+
+// AppendAudit adds entries to the audit log file
+func (f *FileStorage) AppendAudit(entries ...AuditEntry) error {
+	records := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		if entry.Time.IsZero() {
+			entry.Time = time.Now()
+		}
+		records[i] = entry
+	}
+	return f.files.Append(AuditFile, records...)
+}
+
+// LoadAudit reads the audit log file
+func (f *FileStorage) LoadAudit() ([]AuditEntry, error) {
+	var entries []AuditEntry
+	line := 0
+	err := f.files.ReadLines(AuditFile, func(data []byte) error {
+		line++
+		var entry AuditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return fmt.Errorf("%s line %d: %v", AuditFile, line, err)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// Remove deletes the given state files, along with the gob files of older
+// versions that would otherwise be migrated into them again
+func (f *FileStorage) Remove(names ...string) error {
+	for _, fileName := range names {
+		if err := f.files.Remove(fileName); err != nil {
+			return err
+		}
+		for _, legacy := range gobFiles {
+			if legacy.fileName != fileName {
+				continue
+			}
+			if err := f.files.Remove(legacy.gobName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveBackups deletes the earlier generations of a state file, including
+// the gob file it was migrated from
+func (f *FileStorage) RemoveBackups(fileName string) error {
+	if err := f.files.RemoveBackups(fileName); err != nil {
+		return err
+	}
+	for _, legacy := range gobFiles {
+		if legacy.fileName != fileName {
+			continue
+		}
+		if err := f.files.Remove(legacy.gobName + ".gob"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
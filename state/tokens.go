@@ -0,0 +1,27 @@
+package state
+
+import (
+	"github.com/ihoru/instapaper-to-exist/existio_client"
+)
+
+// ExistTokenStore keeps the Exist.io tokens in the sessions of a Storage
+type ExistTokenStore struct {
+	storage  Storage
+	sessions *Sessions
+}
+
+// NewExistTokenStore creates an ExistTokenStore. The tokens are read from and
+// written to sessions, which are saved to storage whenever the tokens change,
+// so sessions saved later in the run carry the new tokens too.
+func NewExistTokenStore(storage Storage, sessions *Sessions) *ExistTokenStore {
+	return &ExistTokenStore{storage: storage, sessions: sessions}
+}
+
+func (t *ExistTokenStore) Load() (existio_client.ExistAuth, error) {
+	return t.sessions.Exist, nil
+}
+
+func (t *ExistTokenStore) Save(tokens existio_client.ExistAuth) error {
+	t.sessions.Exist = tokens
+	return t.storage.SaveSessions(t.sessions)
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockFile is the file locked while a run uses the state directory
+const LockFile = "lock"
+
+// lockPollInterval is how often a held lock is retried
+const lockPollInterval = 200 * time.Millisecond
+
+// Lock takes an advisory lock on the state directory so that concurrent runs
+// don't read and write the same state. If another process holds the lock, it
+// is retried until the timeout elapses. The lock is released by Unlock or
+// when the process exits.
+func (s *Storage) Lock(timeout time.Duration) error {
+	if s.lock != nil {
+		return nil
+	}
+
+	lockPath := filepath.Join(s.stateDir, LockFile)
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file %s: %v", lockPath, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to lock %s: %v", lockPath, err)
+		}
+		if locked {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			holder := lockHolder(file)
+			file.Close()
+			return fmt.Errorf("the state directory %s is in use by another instance%s, gave up after %v",
+				s.stateDir, holder, timeout)
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	// Record the holder for the error message of other instances
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	s.lock = file
+	return nil
+}
+
+// Unlock releases the lock taken by Lock
+func (s *Storage) Unlock() error {
+	if s.lock == nil {
+		return nil
+	}
+	err := unlock(s.lock)
+	s.lock.Close()
+	s.lock = nil
+	return err
+}
+
+// lockHolder describes the process that holds the lock, if it is known
+func lockHolder(file *os.File) string {
+	content := make([]byte, 32)
+	n, _ := file.ReadAt(content, 0)
+	pid := strings.TrimSpace(string(content[:n]))
+	if pid == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (pid %s)", pid)
+}
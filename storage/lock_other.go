@@ -0,0 +1,18 @@
+//go:build !unix
+
+package storage
+
+import (
+	"log"
+	"os"
+)
+
+// tryLock doesn't lock on platforms without flock
+func tryLock(file *os.File) (bool, error) {
+	log.Println("Warning: locking the state directory is not supported on this platform")
+	return true, nil
+}
+
+func unlock(file *os.File) error {
+	return nil
+}
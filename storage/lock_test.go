@@ -0,0 +1,93 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		held    bool          // another instance holds the lock
+		release time.Duration // after which the other instance unlocks
+		timeout time.Duration
+		err     bool
+	}{
+		{name: "free", timeout: 0},
+		{name: "held", held: true, timeout: 0, err: true},
+		{name: "held until the timeout", held: true, timeout: 300 * time.Millisecond, err: true},
+		{name: "released before the timeout", held: true, release: 100 * time.Millisecond, timeout: 2 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestStorage(t)
+			if test.held {
+				other, err := NewStorage(s.Dir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := other.Lock(0); err != nil {
+					t.Fatal(err)
+				}
+				released := make(chan struct{})
+				if test.release > 0 {
+					time.AfterFunc(test.release, func() {
+						other.Unlock()
+						close(released)
+					})
+				} else {
+					close(released)
+				}
+				t.Cleanup(func() {
+					<-released
+					other.Unlock()
+				})
+			}
+
+			start := time.Now()
+			err := s.Lock(test.timeout)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if err != nil {
+				if elapsed := time.Since(start); elapsed < test.timeout {
+					t.Errorf("gave up after %v, before the timeout of %v", elapsed, test.timeout)
+				}
+				if !strings.Contains(err.Error(), "pid "+strconv.Itoa(os.Getpid())) {
+					t.Errorf("the error doesn't name the holder: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestLockIsReleasedByUnlock(t *testing.T) {
+	s := newTestStorage(t)
+	if err := s.Lock(0); err != nil {
+		t.Fatal(err)
+	}
+	// Locking again keeps the lock
+	if err := s.Lock(0); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewStorage(s.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Unlock() })
+	if err := other.Lock(0); err == nil {
+		t.Fatal("locked a state directory that is in use")
+	}
+	if err := s.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Lock(0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// document is the layout of a JSON state file
+type document struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Migration upgrades the data of a state file by one schema version
+type Migration func(data json.RawMessage) (json.RawMessage, error)
+
+// Schema describes the current version of a state file and how to reach it
+// from older ones
+type Schema struct {
+	Version    int
+	Migrations map[int]Migration // keyed by the version they upgrade from
+}
+
+var schemas = make(map[string]Schema)
+
+// RegisterSchema declares the schema of a state file.
+// Files without a registered schema are at version 1.
+func RegisterSchema(fileName string, schema Schema) {
+	schemas[fileName] = schema
+}
+
+func schemaOf(fileName string) Schema {
+	if schema, ok := schemas[fileName]; ok {
+		return schema
+	}
+	return Schema{Version: 1}
+}
+
+// upgrade applies the migrations from the given version to the current one
+func (s Schema) upgrade(version int, data json.RawMessage) (json.RawMessage, error) {
+	if version > s.Version {
+		return nil, fmt.Errorf("version %d was written by a newer release (supported up to %d)", version, s.Version)
+	}
+	for ; version < s.Version; version++ {
+		migrate, ok := s.Migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration from version %d", version)
+		}
+		var err error
+		if data, err = migrate(data); err != nil {
+			return nil, fmt.Errorf("migration from version %d: %v", version, err)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Parameters of the key derivation and the format of encrypted values
+const (
+	secretPrefix     = "enc:v1:"
+	secretSaltSize   = 16
+	secretIterations = 600000 // PBKDF2-HMAC-SHA256, as recommended by OWASP
+	secretKeySize    = 32     // AES-256
+)
+
+// Cipher encrypts secrets such as OAuth tokens with AES-GCM, using a key
+// derived from a passphrase or the content of a keyfile with PBKDF2. Every
+// encrypted value carries the salt of its key, so the passphrase is all that
+// is needed to decrypt it.
+type Cipher struct {
+	secret string
+
+	mu   sync.Mutex
+	salt []byte                 // salt of the key new values are encrypted with
+	keys map[string]cipher.AEAD // derived keys by salt, as derivation is slow on purpose
+}
+
+// NewCipher creates a Cipher from a passphrase or the content of a keyfile
+func NewCipher(secret []byte) (*Cipher, error) {
+	value := strings.TrimSpace(string(secret))
+	if value == "" {
+		return nil, errors.New("the secrets passphrase is empty")
+	}
+	return &Cipher{secret: value, keys: make(map[string]cipher.AEAD)}, nil
+}
+
+// IsEncrypted reports whether a value was encrypted by a Cipher
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// Encrypt encrypts a value. The result is printable and starts with a
+// prefix that tells it apart from plaintext values.
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.salt == nil {
+		salt := make([]byte, secretSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		c.salt = salt
+	}
+	aead, err := c.key(c.salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := append(append([]byte(nil), c.salt...), nonce...)
+	sealed = aead.Seal(sealed, nonce, []byte(plaintext), nil)
+	return secretPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value returned by Encrypt. It fails if the value was
+// encrypted with another passphrase or was tampered with.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", errors.New("the value is not encrypted")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil || len(sealed) < secretSaltSize {
+		return "", errors.New("the encrypted value is malformed")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	salt, sealed := sealed[:secretSaltSize], sealed[secretSaltSize:]
+	aead, err := c.key(salt)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("the encrypted value is malformed")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("the value can't be decrypted, the passphrase or keyfile is wrong")
+	}
+	// Keep encrypting with this key instead of deriving another one
+	if c.salt == nil {
+		c.salt = append([]byte(nil), salt...)
+	}
+	return string(plaintext), nil
+}
+
+// key returns the AES-GCM cipher of the key derived with salt
+func (c *Cipher) key(salt []byte) (cipher.AEAD, error) {
+	if aead, ok := c.keys[string(salt)]; ok {
+		return aead, nil
+	}
+	key, err := pbkdf2.Key(sha256.New, c.secret, salt, secretIterations, secretKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive the key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.keys[string(salt)] = aead
+	return aead, nil
+}
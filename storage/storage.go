@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"bufio"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -9,72 +13,363 @@ import (
 )
 
 func init() {
-	// Register types for gob encoding/decoding
+	// Register types for decoding the gob files of older versions
 	gob.Register(time.Time{})
 }
 
 // Storage handles persistent storage operations
 type Storage struct {
 	stateDir string
+	lock     *os.File // held by Lock
 }
 
-// NewStorage creates a new Storage instance
-func NewStorage(appName string) *Storage {
-	// Set up state directory
+// DefaultDir returns the state directory of the application in the user's home directory
+func DefaultDir(appName string) (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatalf("Failed to get user home directory: %v", err)
+		return "", err
 	}
-	stateDir := filepath.Join(homeDir, ".local", "state", appName)
-	if err := os.MkdirAll(stateDir, 0755); err != nil {
-		log.Fatalf("Failed to create state directory: %v", err)
+	return filepath.Join(homeDir, ".local", "state", appName), nil
+}
+
+// Permissions of the state directory and its files, which hold OAuth tokens
+const (
+	dirMode  os.FileMode = 0700
+	fileMode os.FileMode = 0600
+)
+
+// NewStorage creates a Storage in the given directory, creating it if needed
+func NewStorage(stateDir string) (*Storage, error) {
+	if err := os.MkdirAll(stateDir, dirMode); err != nil {
+		return nil, err
 	}
 
 	return &Storage{
 		stateDir: stateDir,
+	}, nil
+}
+
+// PermissionsRestricted reports whether the state directory and its files
+// are accessible to the owner only
+func (s *Storage) PermissionsRestricted() bool {
+	loose, err := s.loosePaths()
+	return err == nil && len(loose) == 0
+}
+
+// RestrictPermissions makes the state directory and its files accessible to
+// the owner only, tightening the permissions of a directory created by older
+// versions. It should be called under the lock.
+func (s *Storage) RestrictPermissions() error {
+	loose, err := s.loosePaths()
+	if err != nil {
+		return err
 	}
+	for _, path := range loose {
+		mode := fileMode
+		if path == s.stateDir {
+			mode = dirMode
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	if len(loose) > 0 {
+		log.Printf("Restricted the permissions of %s to its owner", s.stateDir)
+	}
+	return nil
 }
 
-// Load loads data from a file using gob decoder
-func (s *Storage) Load(fileName string, data interface{}) error {
-	filePath := filepath.Join(s.stateDir, fileName)
-	if _, err := os.Stat(filePath); err == nil {
-		file, err := os.Open(filePath)
+// loosePaths lists the state directory and the files in it that others can access
+func (s *Storage) loosePaths() ([]string, error) {
+	var loose []string
+	info, err := os.Stat(s.stateDir)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&^dirMode != 0 {
+		loose = append(loose, s.stateDir)
+	}
+
+	entries, err := os.ReadDir(s.stateDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
 		if err != nil {
-			log.Printf("Failed to open file %s: %v", filePath, err)
+			return nil, err
+		}
+		if info.Mode().Perm()&^fileMode != 0 {
+			loose = append(loose, filepath.Join(s.stateDir, entry.Name()))
+		}
+	}
+	return loose, nil
+}
+
+// Dir returns the state directory
+func (s *Storage) Dir() string {
+	return s.stateDir
+}
+
+// Suffixes of the files kept next to a state file
+const (
+	backupSuffix  = ".bak"     // previous generation, written by Save
+	corruptSuffix = ".corrupt" // a generation that couldn't be decoded
+)
+
+// Exists reports whether a state file with the given name, or its backup, exists
+func (s *Storage) Exists(fileName string) bool {
+	for _, name := range []string{fileName, fileName + backupSuffix} {
+		if _, err := os.Stat(filepath.Join(s.stateDir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove deletes a state file and its backup, if they exist
+func (s *Storage) Remove(fileName string) error {
+	for _, name := range []string{fileName, fileName + backupSuffix} {
+		err := os.Remove(filepath.Join(s.stateDir, name))
+		if err != nil && !os.IsNotExist(err) {
 			return err
 		}
-		defer file.Close()
+	}
+	return nil
+}
 
-		decoder := gob.NewDecoder(file)
-		if err := decoder.Decode(data); err != nil {
-			log.Printf("Failed to decode %s: %v", filePath, err)
-			log.Printf("Removing corrupted file and creating a new one.")
-			file.Close()        // Close the file before removing it
-			os.Remove(filePath) // Remove the corrupted file
+// RemoveBackups deletes the backup and the corrupted generations of a state
+// file, e.g. after the file was rewritten without a secret they contain
+func (s *Storage) RemoveBackups(fileName string) error {
+	for _, name := range []string{fileName + backupSuffix, fileName + corruptSuffix} {
+		err := os.Remove(filepath.Join(s.stateDir, name))
+		if err != nil && !os.IsNotExist(err) {
 			return err
 		}
 	}
 	return nil
 }
 
-// Save saves data to a file using gob encoder
-func (s *Storage) Save(fileName string, data interface{}) error {
+// Load loads data from a JSON state file, upgrading it to the current
+// version of its schema. A missing file leaves data untouched. When the file
+// can't be decoded, the backup of the previous generation is loaded instead,
+// and if the storage is locked, the file is kept aside with a .corrupt suffix.
+func (s *Storage) Load(fileName string, data interface{}) error {
 	filePath := filepath.Join(s.stateDir, fileName)
-	file, err := os.Create(filePath)
+	err := s.load(filePath, fileName, data)
+	if err == nil || !errors.Is(err, errCorrupt) && !os.IsNotExist(err) {
+		return err
+	}
+	if !os.IsNotExist(err) {
+		log.Printf("Failed to decode %s: %v", filePath, err)
+		if s.lock != nil {
+			os.Rename(filePath, filePath+corruptSuffix)
+		}
+	}
+
+	backupPath := filePath + backupSuffix
+	if backupErr := s.load(backupPath, fileName, data); backupErr != nil {
+		if os.IsNotExist(backupErr) {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			log.Printf("No backup of %s found, starting over", filePath)
+			return err
+		}
+		log.Printf("Failed to decode the backup %s: %v", backupPath, backupErr)
+		if errors.Is(backupErr, errCorrupt) && s.lock != nil {
+			os.Rename(backupPath, backupPath+corruptSuffix)
+		}
+		return backupErr
+	}
+	log.Printf("Loaded the previous generation of %s from its backup", fileName)
+	return nil
+}
+
+// errCorrupt marks state files whose content can't be decoded
+var errCorrupt = errors.New("corrupted state file")
+
+func (s *Storage) load(filePath, fileName string, data interface{}) error {
+	content, err := os.ReadFile(filePath)
 	if err != nil {
-		log.Printf("Failed to create file %s: %v", filePath, err)
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read file %s: %v", filePath, err)
+		}
 		return err
 	}
-	defer file.Close()
 
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(data); err != nil {
+	var doc document
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+
+	raw, err := schemaOf(fileName).upgrade(doc.Version, doc.Data)
+	if err != nil {
+		log.Printf("Failed to migrate %s: %v", filePath, err)
+		return err
+	}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+	return nil
+}
+
+// Save saves data to a JSON state file at the current version of its schema
+func (s *Storage) Save(fileName string, data interface{}) error {
+	return s.save(fileName, schemaOf(fileName).Version, data)
+}
+
+// save writes the file atomically: the data is written to a temporary file
+// and synced before it replaces the previous generation, which is kept as a backup
+func (s *Storage) save(fileName string, version int, data interface{}) error {
+	filePath := filepath.Join(s.stateDir, fileName)
+	raw, err := json.Marshal(data)
+	if err != nil {
 		log.Printf("Failed to encode %s: %v", filePath, err)
-		// If encoding fails, remove the potentially corrupted file
+		return err
+	}
+	content, err := json.MarshalIndent(document{Version: version, Data: raw}, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode %s: %v", filePath, err)
+		return err
+	}
+
+	if err := s.writeTemp(filePath, append(content, '\n')); err != nil {
+		log.Printf("Failed to write file %s: %v", filePath, err)
+		return err
+	}
+
+	if _, err := os.Stat(filePath); err == nil {
+		if err := os.Rename(filePath, filePath+backupSuffix); err != nil {
+			log.Printf("Failed to back up %s: %v", filePath, err)
+			os.Remove(filePath + tempSuffix)
+			return err
+		}
+	}
+	if err := os.Rename(filePath+tempSuffix, filePath); err != nil {
+		log.Printf("Failed to replace %s: %v", filePath, err)
+		return err
+	}
+	return syncDir(s.stateDir)
+}
+
+// tempSuffix is the suffix of the file a new generation is written to
+const tempSuffix = ".tmp"
+
+// writeTemp writes content next to filePath and syncs it to disk
+func (s *Storage) writeTemp(filePath string, content []byte) error {
+	tempPath := filePath + tempSuffix
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
 		file.Close()
-		os.Remove(filePath)
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// syncDir makes the renames in a directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
 		return err
 	}
 	return nil
 }
+
+// MigrateGob converts a gob state file written by older versions into the
+// JSON state file fileName, holding data of the given schema version. The gob
+// file is kept with a .gob extension. Nothing happens if the gob file doesn't
+// exist or the JSON file already does.
+func (s *Storage) MigrateGob(gobName, fileName string, version int, data interface{}) error {
+	gobPath := filepath.Join(s.stateDir, gobName)
+	if !s.Exists(gobName) || s.Exists(fileName) {
+		return nil
+	}
+
+	file, err := os.Open(gobPath)
+	if err != nil {
+		return err
+	}
+	err = gob.NewDecoder(file).Decode(data)
+	file.Close()
+	if err != nil {
+		// Keep the corrupted file aside instead of failing on every run
+		os.Rename(gobPath, gobPath+".gob")
+		return fmt.Errorf("failed to decode %s: %v", gobPath, err)
+	}
+
+	if err := s.save(fileName, version, data); err != nil {
+		return err
+	}
+	log.Printf("Migrated %s to %s", gobName, fileName)
+	return os.Rename(gobPath, gobPath+".gob")
+}
+
+// Append adds records as lines of JSON to a log file
+func (s *Storage) Append(fileName string, records ...interface{}) error {
+	var lines []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	filePath := filepath.Join(s.stateDir, fileName)
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, fileMode)
+	if err != nil {
+		log.Printf("Failed to open file %s: %v", filePath, err)
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(lines); err != nil {
+		log.Printf("Failed to append to %s: %v", filePath, err)
+		return err
+	}
+	return nil
+}
+
+// ReadLines calls fn with every line of a log file, if it exists
+func (s *Storage) ReadLines(fileName string, fn func(line []byte) error) error {
+	file, err := os.Open(filepath.Join(s.stateDir, fileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestStorage returns a storage in a temporary directory
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Unlock() })
+	return s
+}
+
+// writeFile writes content to a file in the state directory
+func writeFile(t *testing.T, s *Storage, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(s.Dir(), name), []byte(content), fileMode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exists reports whether a file exists in the state directory
+func exists(s *Storage, name string) bool {
+	_, err := os.Stat(filepath.Join(s.Dir(), name))
+	return err == nil
+}
+
+func init() {
+	// names.json holds a list of names at version 1 and a set of them since version 2
+	RegisterSchema("names.json", Schema{
+		Version: 3,
+		Migrations: map[int]Migration{
+			1: func(data json.RawMessage) (json.RawMessage, error) {
+				var names []string
+				if err := json.Unmarshal(data, &names); err != nil {
+					return nil, err
+				}
+				set := make(map[string]bool, len(names))
+				for _, name := range names {
+					set[name] = true
+				}
+				return json.Marshal(set)
+			},
+			2: func(data json.RawMessage) (json.RawMessage, error) {
+				return data, nil
+			},
+		},
+	})
+	RegisterSchema("gap.json", Schema{Version: 2})
+}
+
+func TestLoadUpgradesSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+		want     map[string]bool
+		err      string
+	}{
+		{"current version", "names.json", `{"version": 3, "data": {"a": true}}`, map[string]bool{"a": true}, ""},
+		{"older version", "names.json", `{"version": 1, "data": ["a", "b"]}`, map[string]bool{"a": true, "b": true}, ""},
+		{"intermediate version", "names.json", `{"version": 2, "data": {"a": true}}`, map[string]bool{"a": true}, ""},
+		{"newer version", "names.json", `{"version": 4, "data": {"a": true}}`, nil, "written by a newer release"},
+		{"failed migration", "names.json", `{"version": 1, "data": {"a": true}}`, nil, "migration from version 1"},
+		{"missing migration", "gap.json", `{"version": 1, "data": {"a": true}}`, nil, "no migration from version 1"},
+		{"unregistered schema", "plain.json", `{"version": 1, "data": {"a": true}}`, map[string]bool{"a": true}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestStorage(t)
+			writeFile(t, s, test.fileName, test.content)
+
+			var got map[string]bool
+			err := s.Load(test.fileName, &got)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				if !exists(s, test.fileName) {
+					t.Error("a file that can't be upgraded was moved away")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSaveWritesCurrentVersion(t *testing.T) {
+	s := newTestStorage(t)
+	if err := s.Save("names.json", map[string]bool{"a": true}); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(s.Dir(), "names.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc document
+	if err := json.Unmarshal(content, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != 3 {
+		t.Errorf("saved version %d, want 3", doc.Version)
+	}
+}
+
+func TestMigrateGob(t *testing.T) {
+	tests := []struct {
+		name     string
+		gob      bool   // a valid gob file exists
+		gobBytes string // raw content of the gob file instead
+		json     bool   // the JSON file exists already
+		want     map[string]bool
+		migrated bool // the gob file was kept with a .gob extension
+		err      bool
+	}{
+		{name: "converts the gob file", gob: true, want: map[string]bool{"a": true}, migrated: true},
+		{name: "keeps an existing JSON file", gob: true, json: true, want: map[string]bool{"b": true}},
+		{name: "nothing to migrate"},
+		{name: "corrupted gob file", gobBytes: "not gob", migrated: true, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestStorage(t)
+			switch {
+			case test.gob:
+				file, err := os.Create(filepath.Join(s.Dir(), "names"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := gob.NewEncoder(file).Encode(map[string]bool{"a": true}); err != nil {
+					t.Fatal(err)
+				}
+				file.Close()
+			case test.gobBytes != "":
+				writeFile(t, s, "names", test.gobBytes)
+			}
+			if test.json {
+				if err := s.Save("names.json", map[string]bool{"b": true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var data map[string]bool
+			err := s.MigrateGob("names", "names.json", 2, &data)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if exists(s, "names.gob") != test.migrated {
+				t.Errorf("names.gob exists: %v, want %v", exists(s, "names.gob"), test.migrated)
+			}
+			if test.migrated && exists(s, "names") {
+				t.Error("the gob file is still in place")
+			}
+
+			var got map[string]bool
+			if err := s.Load("names.json", &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMigrateGobUpgradesFromItsVersion(t *testing.T) {
+	s := newTestStorage(t)
+	file, err := os.Create(filepath.Join(s.Dir(), "names"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(file).Encode([]string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	var names []string
+	if err := s.MigrateGob("names", "names.json", 1, &names); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]bool
+	if err := s.Load("names.json", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, map[string]bool{"a": true}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestSaveKeepsPreviousGeneration(t *testing.T) {
+	s := newTestStorage(t)
+	for _, value := range []string{"first", "second", "third"} {
+		if err := s.Save("plain.json", map[string]bool{value: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exists(s, "plain.json"+tempSuffix) {
+		t.Error("the temporary file was left behind")
+	}
+
+	for name, want := range map[string]string{"plain.json": "third", "plain.json" + backupSuffix: "second"} {
+		info, err := os.Stat(filepath.Join(s.Dir(), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != fileMode {
+			t.Errorf("%s has mode %v, want %v", name, info.Mode().Perm(), fileMode)
+		}
+		var got map[string]bool
+		if err := s.load(filepath.Join(s.Dir(), name), "plain.json", &got); err != nil {
+			t.Fatal(err)
+		}
+		if !got[want] || len(got) != 1 {
+			t.Errorf("%s holds %v, want %s", name, got, want)
+		}
+	}
+}
+
+func TestSaveLeavesStateIntactOnFailure(t *testing.T) {
+	s := newTestStorage(t)
+	if err := s.Save("plain.json", map[string]bool{"a": true}); err != nil {
+		t.Fatal(err)
+	}
+	// Values that can't be encoded fail before anything is written
+	if err := s.Save("plain.json", map[string]interface{}{"a": make(chan int)}); err == nil {
+		t.Fatal("saved a value that can't be encoded")
+	}
+	if exists(s, "plain.json"+tempSuffix) || exists(s, "plain.json"+backupSuffix) {
+		t.Error("a failed save touched the state directory")
+	}
+	var got map[string]bool
+	if err := s.Load("plain.json", &got); err != nil || !got["a"] {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
+
+func TestLoadFallsBackToBackup(t *testing.T) {
+	const (
+		valid   = `{"version": 1, "data": {"current": true}}`
+		backup  = `{"version": 1, "data": {"backup": true}}`
+		corrupt = `{"version": 1, "data": `
+	)
+	tests := []struct {
+		name        string
+		file        string // content of the state file, if it exists
+		backup      string // content of its backup, if it exists
+		locked      bool
+		want        map[string]bool
+		err         bool
+		quarantined []string // files kept aside with a .corrupt suffix
+	}{
+		{name: "valid file", file: valid, backup: backup, want: map[string]bool{"current": true}},
+		{name: "missing file", want: nil},
+		{name: "missing file with backup", backup: backup, want: map[string]bool{"backup": true}},
+		{name: "corrupted file", file: corrupt, backup: backup, locked: true,
+			want: map[string]bool{"backup": true}, quarantined: []string{"plain.json"}},
+		{name: "corrupted file without lock", file: corrupt, backup: backup,
+			want: map[string]bool{"backup": true}},
+		{name: "corrupted file without backup", file: corrupt, locked: true,
+			err: true, quarantined: []string{"plain.json"}},
+		{name: "corrupted file and backup", file: corrupt, backup: corrupt, locked: true,
+			err: true, quarantined: []string{"plain.json", "plain.json" + backupSuffix}},
+		{name: "wrong type", file: `{"version": 1, "data": ["current"]}`, backup: backup, locked: true,
+			want: map[string]bool{"backup": true}, quarantined: []string{"plain.json"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestStorage(t)
+			if test.file != "" {
+				writeFile(t, s, "plain.json", test.file)
+			}
+			if test.backup != "" {
+				writeFile(t, s, "plain.json"+backupSuffix, test.backup)
+			}
+			if test.locked {
+				if err := s.Lock(0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got map[string]bool
+			err := s.Load("plain.json", &got)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v", err)
+			}
+			if !test.err && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+			quarantined := map[string]bool{}
+			for _, name := range test.quarantined {
+				quarantined[name] = true
+			}
+			for _, name := range []string{"plain.json", "plain.json" + backupSuffix} {
+				if exists(s, name+corruptSuffix) != quarantined[name] {
+					t.Errorf("%s exists: %v, want %v", name+corruptSuffix, !quarantined[name], quarantined[name])
+				}
+				present := name == "plain.json" && test.file != "" || name != "plain.json" && test.backup != ""
+				if exists(s, name) != (present && !quarantined[name]) {
+					t.Errorf("%s exists: %v", name, exists(s, name))
+				}
+			}
+		})
+	}
+}
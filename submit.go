@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ihoru/instapaper-to-exist/existio_client"
+)
+
+// BuildSubmissions computes the value of every configured attribute for each
+// date. Besides the submissions, it returns the values as a per-date table.
+func (a *App) BuildSubmissions(attrs *existio_client.Attrs, dates []time.Time, reading *Reading) (*existio_client.Submissions, [][]string, error) {
+	types, err := a.AttributeTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	submissions := attrs.NewSubmissions(types)
+	var plan [][]string
+	for _, date := range dates {
+		dateStr := date.Format("2006-01-02")
+		row := []string{dateStr}
+		var values []string
+		for _, mapping := range a.Config.Attributes {
+			value := Aggregate(mapping, dateStr, reading)
+			row = append(row, fmt.Sprint(value))
+			values = append(values, fmt.Sprintf("%s=%v", mapping.Name, value))
+			if err := submissions.Add(date, mapping.Name, value); err != nil {
+				return nil, nil, fmt.Errorf("invalid submission: %v", err)
+			}
+		}
+		plan = append(plan, row)
+		log.Printf("%s: %s", dateStr, strings.Join(values, ", "))
+	}
+	return submissions, plan, nil
+}
+
+// DateRange returns every day from the first date, the start of a day, to the last, inclusive
+func DateRange(first, last time.Time) []time.Time {
+	var dates []time.Time
+	for date := first; !date.After(last); date = date.AddDate(0, 0, 1) {
+		dates = append(dates, date)
+	}
+	return dates
+}
+
+// SubmitInChunks submits the data with one UpdateBatch call per chunk,
+// reporting the progress after each one
+func SubmitInChunks(attrs *existio_client.Attrs, data []map[string]interface{}, chunkSize int) error {
+	submitted := 0
+	for _, chunk := range attrs.ChunkSubmissions(data, chunkSize) {
+		if err := attrs.UpdateBatch(chunk); err != nil {
+			return fmt.Errorf("failed after %d of %d values: %v", submitted, len(data), err)
+		}
+		submitted += len(chunk)
+		log.Printf("Submitted %d/%d values (up to %s)", submitted, len(data), chunk[len(chunk)-1]["date"])
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/ihoru/instapaper-to-exist/instapaper_client"
+	"github.com/ihoru/instapaper-to-exist/state"
+)
+
+// maxArticleSize limits how much of a linked page is downloaded
+const maxArticleSize = 5 << 20
+
+var (
+	invisibleElements = regexp.MustCompile(`(?is)<(script|style|noscript|template|svg|head)\b.*?</(script|style|noscript|template|svg|head)\s*>`)
+	htmlComments      = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlTags          = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// CountWords counts the words of the visible text in an HTML document
+func CountWords(document string) int {
+	text := invisibleElements.ReplaceAllString(document, " ")
+	text = htmlComments.ReplaceAllString(text, " ")
+	text = htmlTags.ReplaceAllString(text, " ")
+	text = html.UnescapeString(text)
+
+	words := 0
+	for _, field := range strings.Fields(text) {
+		// Skip stray punctuation such as dashes and bullets
+		if strings.IndexFunc(field, isWordRune) >= 0 {
+			words++
+		}
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127
+}
+
+// ReadingMinutes estimates the minutes needed to read the given number of words
+func (a *App) ReadingMinutes(words int) int {
+	if words <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(words) / float64(a.Config.ReadingWordsPerMinute)))
+}
+
+// FetchArticleText downloads the article text, preferring Instapaper's
+// processed text view and falling back to the article link
+func FetchArticleText(instapaper *instapaper_client.Client, client *http.Client, article state.Article) (string, error) {
+	if instapaper != nil && article.BookmarkID != 0 {
+		text, err := instapaper.GetText(article.BookmarkID)
+		if err == nil {
+			return text, nil
+		}
+		log.Printf("Failed to get text of %s from Instapaper: %v", article.GUID, err)
+	}
+
+	if article.Link == "" {
+		return "", fmt.Errorf("article has no link")
+	}
+	resp, err := client.Get(article.Link)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.Contains(contentType, "html") && !strings.HasPrefix(contentType, "text/") {
+		return "", fmt.Errorf("unsupported content type: %s", contentType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxArticleSize))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// MeasureArticle fills in the word count and reading time of an article
+func (a *App) MeasureArticle(instapaper *instapaper_client.Client, client *http.Client, article *state.Article) error {
+	text, err := FetchArticleText(instapaper, client, *article)
+	if err != nil {
+		return err
+	}
+	article.Words = CountWords(text)
+	article.Minutes = a.ReadingMinutes(article.Words)
+	return nil
+}
+
+// DayReading sums the words and reading minutes of the articles credited to a date
+func DayReading(date string, articles state.Articles) (words int, minutes int) {
+	for _, article := range articles {
+		if article.Day == date {
+			words += article.Words
+			minutes += article.Minutes
+		}
+	}
+	return words, minutes
+}